@@ -0,0 +1,153 @@
+package ci
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// ArtifactStore stores files published by tasks, so that other tasks can use them
+type ArtifactStore interface {
+	// Put stores files at paths, relative to base, under name
+	Put(name, base string, paths []string) error
+	// Get copies the files stored under name into destination
+	Get(name, destination string) error
+}
+
+// FileArtifacts stores artifacts as directories on the filesystem
+type FileArtifacts struct {
+	Dir string
+
+	mu sync.RWMutex
+}
+
+// NewFileArtifacts creates an artifact store in dir.
+func NewFileArtifacts(dir string) *FileArtifacts {
+	return &FileArtifacts{Dir: dir}
+}
+
+// Put copies files into the artifact directory, publishing
+// the same name multiple times merges the files.
+func (store *FileArtifacts) Put(name, base string, paths []string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	target := filepath.Join(store.Dir, sanitizeKey(name))
+	if err := os.MkdirAll(target, 0777); err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(target, path)), 0777); err != nil {
+			return err
+		}
+		if err := copyAny(base, path, target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Get copies the artifact files into destination.
+func (store *FileArtifacts) Get(name, destination string) error {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	source := filepath.Join(store.Dir, sanitizeKey(name))
+	infos, err := ioutil.ReadDir(source)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("artifact %q not found", name)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(destination, 0777); err != nil {
+		return err
+	}
+	for _, info := range infos {
+		if err := copyAny(source, info.Name(), destination); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Artifact publishes files matching a glob to the artifact store
+type Artifact struct {
+	Name string
+	Glob string
+}
+
+// Setup sets up the step
+func (step *Artifact) Setup(parent *Task) {
+	task := parent.Subtask("artifact %q := %q", step.Name, step.Glob)
+	task.Exec = func(context, _ *Context) error {
+		glob, prefix, err := context.AbsGlob(step.Glob)
+		if err != nil {
+			return err
+		}
+		base := globBase(prefix)
+
+		matches, err := filepath.Glob(glob)
+		if err != nil {
+			return err
+		}
+		if len(matches) == 0 {
+			if context.Global.DryRun {
+				context.Printf("would publish %q to artifact %q, no files match yet", glob, step.Name)
+				return nil
+			}
+			return fmt.Errorf("no files match %q [expanded %q]", step.Glob, glob)
+		}
+
+		var paths []string
+		for _, match := range matches {
+			rel, err := filepath.Rel(base, match)
+			if err != nil {
+				return err
+			}
+			paths = append(paths, rel)
+		}
+
+		if context.Global.DryRun {
+			for _, path := range paths {
+				context.Printf("would publish %q to artifact %q", filepath.Join(base, path), step.Name)
+			}
+			return nil
+		}
+
+		return context.Global.Artifacts.Put(step.Name, base, paths)
+	}
+}
+
+// UseArtifact copies a published artifact into a directory
+type UseArtifact struct {
+	Name        string
+	Destination string
+}
+
+// Setup sets up the step
+func (step *UseArtifact) Setup(parent *Task) {
+	task := parent.Subtask("use artifact %q %q", step.Name, step.Destination)
+	task.Exec = func(context, _ *Context) error {
+		destination, err := context.ExpandEnv(step.Destination)
+		if err != nil {
+			return err
+		}
+
+		destination, err = filepath.Abs(destination)
+		if err != nil {
+			return err
+		}
+
+		if context.Global.DryRun {
+			context.Printf("would copy artifact %q to %q", step.Name, destination)
+			return nil
+		}
+
+		return context.Global.Artifacts.Get(step.Name, destination)
+	}
+}
@@ -0,0 +1,104 @@
+package ci
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileArtifacts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ci-artifact-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	source := filepath.Join(dir, "source")
+	writeFile(t, filepath.Join(source, "bin", "app"), "binary")
+	writeFile(t, filepath.Join(source, "docs", "api", "index.html"), "docs")
+	writeFile(t, filepath.Join(source, "README"), "readme")
+
+	store := NewFileArtifacts(filepath.Join(dir, "store"))
+	if err := store.Put("release/linux", source, []string{"bin/app", "docs"}); err != nil {
+		t.Fatal(err)
+	}
+	// publishing the same name again merges the files
+	if err := store.Put("release/linux", source, []string{"README"}); err != nil {
+		t.Fatal(err)
+	}
+
+	destination := filepath.Join(dir, "destination", "nested")
+	if err := store.Get("release/linux", destination); err != nil {
+		t.Fatal(err)
+	}
+	expectFile(t, filepath.Join(destination, "bin", "app"), "binary")
+	expectFile(t, filepath.Join(destination, "docs", "api", "index.html"), "docs")
+	expectFile(t, filepath.Join(destination, "README"), "readme")
+
+	if err := store.Get("missing", destination); err == nil {
+		t.Error("expected an error for a missing artifact")
+	}
+}
+
+func TestArtifactSteps(t *testing.T) {
+	global, cleanup := newTestContext(t)
+	defer cleanup()
+
+	dir, err := ioutil.TempDir("", "ci-artifact-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeFile(t, filepath.Join(dir, "build", "app.exe"), "windows")
+	writeFile(t, filepath.Join(dir, "build", "app"), "linux")
+	writeFile(t, filepath.Join(dir, "build", "log.txt"), "log")
+
+	root := &Task{Name: "Default"}
+	root.AddSteps([]Step{
+		&Artifact{Name: "binaries", Glob: filepath.Join(dir, "build", "app*")},
+		&UseArtifact{Name: "binaries", Destination: filepath.Join(dir, "deploy")},
+	})
+	if err := root.Run(&global.Context); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expectFile(t, filepath.Join(dir, "deploy", "app.exe"), "windows")
+	expectFile(t, filepath.Join(dir, "deploy", "app"), "linux")
+	if _, err := os.Stat(filepath.Join(dir, "deploy", "log.txt")); !os.IsNotExist(err) {
+		t.Errorf("expected only the matching files to be published, got %v", err)
+	}
+
+	missing := &Task{Name: "Default"}
+	missing.AddSteps([]Step{&Artifact{Name: "missing", Glob: filepath.Join(dir, "nothing", "*")}})
+	if err := missing.Run(&global.Context); err == nil {
+		t.Error("expected an error when no files match")
+	}
+}
+
+func TestKeepArtifacts(t *testing.T) {
+	for _, keep := range []bool{false, true} {
+		global, cleanup := newTestContext(t)
+		global.KeepArtifacts = keep
+
+		writeFile(t, filepath.Join(global.TempDir(), "temp", "scratch"), "scratch")
+		if err := global.Artifacts.Put("report", global.TempDir(), []string{"temp/scratch"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := global.Cleanup(); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err := os.Stat(filepath.Join(global.ArtifactDir(), "report", "temp", "scratch"))
+		if kept := err == nil; kept != keep {
+			t.Errorf("KeepArtifacts=%v: got artifact kept %v", keep, kept)
+		}
+		if _, err := os.Stat(filepath.Join(global.TempDir(), "temp")); !os.IsNotExist(err) {
+			t.Errorf("KeepArtifacts=%v: expected the temporary directory to be removed, got %v", keep, err)
+		}
+
+		_ = os.RemoveAll(global.TempDir())
+		cleanup()
+	}
+}
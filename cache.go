@@ -0,0 +1,246 @@
+package ci
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrCached is used to skip the subtasks of a task, when their results were restored from cache
+var ErrCached = errors.New("cached")
+
+// Cached skips steps when their inputs have not changed since the last
+// successful run and restores their outputs from the cache instead
+type Cached struct {
+	Key string
+	// Inputs are globs of files that affect the result
+	Inputs []string
+	// Outputs are globs of files produced by the steps
+	Outputs []string
+	// Env are the names of environment variables that affect the result
+	Env   []string
+	Steps []Step
+}
+
+// Setup sets up the step
+func (step *Cached) Setup(parent *Task) {
+	task := parent.Subtask("cached %q", step.Key)
+
+	task.Exec = func(context, subcontext *Context) error {
+		hash, err := step.hash(subcontext, task)
+		if err != nil {
+			return err
+		}
+
+		entry := filepath.Join(context.Global.CacheDir, sanitizeKey(step.Key), hash)
+		// the entry is passed to the store subtask with the context of this attempt
+		subcontext.Context = withCacheEntry(subcontext.Context, task, entry)
+		if _, err := os.Stat(entry); err != nil {
+			return nil
+		}
+
+		if context.Global.DryRun {
+			context.Printf("would restore %q from cache", step.Key)
+			return ErrCached
+		}
+
+		if err := step.restore(subcontext, entry); err != nil {
+			return err
+		}
+		context.Printf("restored %q from cache", step.Key)
+		return ErrCached
+	}
+	task.AddSteps(step.Steps)
+
+	store := task.Subtask("cache %q", step.Key)
+	store.Exec = func(context, _ *Context) error {
+		entry, ok := cacheEntry(context, task)
+		if !ok {
+			return fmt.Errorf("cache entry of %q was not computed", step.Key)
+		}
+		if context.Global.DryRun {
+			context.Printf("would store %q in cache", step.Key)
+			return nil
+		}
+		return step.store(context, entry)
+	}
+}
+
+// cacheEntryKey is the context key for the cache entry directory of a Cached task.
+type cacheEntryKey struct{ task *Task }
+
+// withCacheEntry returns ctx with the cache entry directory of task.
+func withCacheEntry(ctx context.Context, task *Task, entry string) context.Context {
+	return context.WithValue(ctx, cacheEntryKey{task}, entry)
+}
+
+// cacheEntry returns the cache entry directory of task stored in ctx.
+func cacheEntry(ctx context.Context, task *Task) (string, bool) {
+	entry, ok := ctx.Value(cacheEntryKey{task}).(string)
+	return entry, ok
+}
+
+// hash computes the cache key from inputs, environment variables and task descriptions.
+func (step *Cached) hash(context *Context, task *Task) (string, error) {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "key %q\n", step.Key)
+
+	for _, name := range step.Env {
+		value, _ := context.GetEnv(name)
+		fmt.Fprintf(hash, "env %q=%q\n", name, value)
+	}
+
+	var describe func(task *Task, ident string)
+	describe = func(task *Task, ident string) {
+		for _, subtask := range task.Tasks {
+			fmt.Fprintf(hash, "task %s%s\n", ident, subtask.Name)
+			describe(subtask, ident+"    ")
+		}
+	}
+	describe(task, "")
+
+	for i, input := range step.Inputs {
+		glob, prefix, err := context.AbsGlob(input)
+		if err != nil {
+			return "", err
+		}
+		base := globBase(prefix)
+
+		matches, err := filepath.Glob(glob)
+		if err != nil {
+			return "", err
+		}
+		sort.Strings(matches)
+
+		for _, match := range matches {
+			err := filepath.Walk(match, func(path string, info os.FileInfo, err error) error {
+				if err != nil || info.IsDir() {
+					return err
+				}
+				rel, err := filepath.Rel(base, path)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(hash, "input %d %q %v\n", i, filepath.ToSlash(rel), info.Mode())
+				return hashFile(hash, path)
+			})
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// store copies the outputs into the cache entry.
+func (step *Cached) store(context *Context, entry string) error {
+	if err := os.MkdirAll(filepath.Dir(entry), 0777); err != nil {
+		return err
+	}
+
+	// store into a temporary directory first, so that partial entries are never used
+	temp, err := ioutil.TempDir(filepath.Dir(entry), "partial")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(temp)
+
+	for i, output := range step.Outputs {
+		glob, prefix, err := context.AbsGlob(output)
+		if err != nil {
+			return err
+		}
+		base := globBase(prefix)
+
+		matches, err := filepath.Glob(glob)
+		if err != nil {
+			return err
+		}
+
+		target := filepath.Join(temp, strconv.Itoa(i))
+		if err := os.Mkdir(target, 0777); err != nil {
+			return err
+		}
+		for _, match := range matches {
+			rel, err := filepath.Rel(base, match)
+			if err != nil {
+				return err
+			}
+			if err := os.MkdirAll(filepath.Dir(filepath.Join(target, rel)), 0777); err != nil {
+				return err
+			}
+			if err := copyAny(base, rel, target); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := os.Rename(temp, entry); err != nil && !os.IsExist(err) {
+		return err
+	}
+	return nil
+}
+
+// restore copies the outputs from the cache entry.
+func (step *Cached) restore(context *Context, entry string) error {
+	for i, output := range step.Outputs {
+		_, prefix, err := context.AbsGlob(output)
+		if err != nil {
+			return err
+		}
+		base := globBase(prefix)
+		if err := os.MkdirAll(base, 0777); err != nil {
+			return err
+		}
+
+		source := filepath.Join(entry, strconv.Itoa(i))
+		infos, err := ioutil.ReadDir(source)
+		if err != nil {
+			return err
+		}
+		for _, info := range infos {
+			if err := copyAny(source, info.Name(), base); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// globBase returns the directory containing the glob prefix.
+func globBase(prefix string) string {
+	return filepath.Dir(prefix + "_")
+}
+
+// sanitizeKey makes key usable as a directory name.
+func sanitizeKey(key string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9', r == '-', r == '.':
+			return r
+		default:
+			return '_'
+		}
+	}, key)
+}
+
+func hashFile(w io.Writer, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(w, file)
+	return err
+}
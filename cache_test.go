@@ -0,0 +1,168 @@
+package ci
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testStep is a step that runs fn in a single task.
+type testStep struct {
+	name string
+	fn   func(context *Context) error
+}
+
+func (step *testStep) Setup(parent *Task) {
+	task := parent.Subtask("%s", step.name)
+	task.Exec = func(context, _ *Context) error { return step.fn(context) }
+}
+
+func TestCached(t *testing.T) {
+	global, cleanup := newTestContext(t)
+	defer cleanup()
+
+	dir, err := ioutil.TempDir("", "ci-cache-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	global.CacheDir = filepath.Join(dir, "cache")
+
+	input := filepath.Join(dir, "in", "input.txt")
+	output := filepath.Join(dir, "out", "gen", "output.txt")
+	writeFile(t, input, "first")
+
+	builds := 0
+	build := &testStep{name: "generate", fn: func(context *Context) error {
+		builds++
+		data, err := ioutil.ReadFile(input)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(output), 0777); err != nil {
+			return err
+		}
+		return ioutil.WriteFile(output, append([]byte("generated "), data...), 0666)
+	}}
+
+	run := func(mode string) *Task {
+		t.Helper()
+		global.Env.Set("MODE", mode)
+
+		root := &Task{Name: "root"}
+		root.AddSteps([]Step{&Cached{
+			Key:     "generate",
+			Inputs:  []string{filepath.Join(dir, "in", "*")},
+			Outputs: []string{filepath.Join(dir, "out", "gen", "*")},
+			Env:     []string{"MODE"},
+			Steps:   []Step{build},
+		}})
+		if err := root.Run(&global.Context); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return root.Tasks[0]
+	}
+
+	// miss runs the steps and stores the outputs
+	cached := run("debug")
+	expectState(t, cached, "done")
+	if builds != 1 {
+		t.Fatalf("got %d builds, expected 1", builds)
+	}
+
+	// hit restores the outputs, including the missing directories
+	if err := os.RemoveAll(filepath.Join(dir, "out")); err != nil {
+		t.Fatal(err)
+	}
+	cached = run("debug")
+	expectState(t, cached, "cached")
+	if builds != 1 {
+		t.Errorf("got %d builds, expected the cached result", builds)
+	}
+	expectFile(t, output, "generated first")
+
+	// changed inputs invalidate the cache
+	writeFile(t, input, "second")
+	cached = run("debug")
+	expectState(t, cached, "done")
+	if builds != 2 {
+		t.Errorf("got %d builds, expected a rebuild after the input changed", builds)
+	}
+	expectFile(t, output, "generated second")
+
+	// changed environment invalidates the cache
+	cached = run("release")
+	expectState(t, cached, "done")
+	if builds != 3 {
+		t.Errorf("got %d builds, expected a rebuild after the environment changed", builds)
+	}
+
+	// the previous entries are still available
+	writeFile(t, input, "first")
+	cached = run("debug")
+	expectState(t, cached, "cached")
+	expectFile(t, output, "generated first")
+	if builds != 3 {
+		t.Errorf("got %d builds, expected the cached result", builds)
+	}
+}
+
+func TestCachedHashIncludesSteps(t *testing.T) {
+	global, cleanup := newTestContext(t)
+	defer cleanup()
+
+	hash := func(steps ...Step) string {
+		t.Helper()
+		step := &Cached{Key: "key", Steps: steps}
+		root := &Task{Name: "root"}
+		step.Setup(root)
+		hash, err := step.hash(&global.Context, root.Tasks[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hash
+	}
+
+	vet := &Run{Command: "go", Args: []string{"vet"}}
+	test := &Run{Command: "go", Args: []string{"test"}}
+	if hash(vet) != hash(vet) {
+		t.Error("expected the same hash for the same steps")
+	}
+	if hash(vet) == hash(test) {
+		t.Error("expected a different hash for different steps")
+	}
+}
+
+func TestCachedStoreWithoutEntry(t *testing.T) {
+	global, cleanup := newTestContext(t)
+	defer cleanup()
+
+	root := &Task{Name: "root"}
+	(&Cached{Key: "key"}).Setup(root)
+	store := root.Tasks[0].Tasks[0]
+	if err := store.Run(&global.Context); err == nil {
+		t.Error("expected an error when the entry was not computed")
+	}
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0666); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func expectFile(t *testing.T, path, expected string) {
+	t.Helper()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != expected {
+		t.Errorf("%s: got %q, expected %q", path, data, expected)
+	}
+}
@@ -0,0 +1,415 @@
+// Package cli implements a command-line runner for pipelines.
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/loov/ci"
+	"github.com/loov/ci/config"
+	"github.com/loov/ci/report"
+	"github.com/loov/ci/term"
+	"github.com/loov/ci/web"
+)
+
+// Exit codes returned by Run.
+const (
+	ExitSuccess     = 0
+	ExitFailure     = 1
+	ExitUsage       = 2
+	ExitInterrupted = 130
+)
+
+// Main runs the command-line interface with os.Args and exits the process.
+func Main(pipelines ci.Pipelines) {
+	os.Exit(Run(os.Args[1:], pipelines))
+}
+
+// MainConfig loads pipelines from a YAML or JSON file and
+// runs the command-line interface with os.Args and exits the process.
+//
+// The file is path, unless specified with a leading -file flag.
+// Custom steps must be registered with ci.RegisterStep before calling MainConfig.
+func MainConfig(path string) {
+	os.Exit(RunConfig(os.Args[1:], path))
+}
+
+// RunConfig loads pipelines from a YAML or JSON file and
+// runs the command-line interface with args and returns the exit code.
+func RunConfig(args []string, path string) int {
+	if len(args) >= 2 && (args[0] == "-file" || args[0] == "--file") {
+		path, args = args[1], args[2:]
+	}
+	if command, _ := parseCommand(args); command == "steps" || command == "help" {
+		return Run(args, nil)
+	}
+
+	pipelines, err := config.Load(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitUsage
+	}
+	return Run(args, pipelines)
+}
+
+// Run runs the command-line interface with args and returns the exit code.
+func Run(args []string, pipelines ci.Pipelines) int {
+	command, args := parseCommand(args)
+	switch command {
+	case "list":
+		return list(os.Stdout, pipelines)
+	case "steps":
+		return steps(os.Stdout)
+	case "show":
+		return run(args, pipelines, true)
+	case "run":
+		return run(args, pipelines, false)
+	default:
+		usage(os.Stderr)
+		return ExitSuccess
+	}
+}
+
+// parseCommand splits the subcommand from args, run is the default.
+func parseCommand(args []string) (command string, rest []string) {
+	if len(args) > 0 {
+		switch args[0] {
+		case "run", "list", "show", "steps", "help":
+			return args[0], args[1:]
+		case "-h", "-help", "--help":
+			return "help", nil
+		}
+	}
+	return "run", args
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintf(w, "Usage:\n")
+	fmt.Fprintf(w, "  %s [run] [flags] [pipeline]   run the pipeline, Default when omitted\n", commandName())
+	fmt.Fprintf(w, "  %s show [flags] [pipeline]    print the task tree without running it\n", commandName())
+	fmt.Fprintf(w, "  %s list                       list pipelines\n", commandName())
+	fmt.Fprintf(w, "  %s steps                      list step kinds and their fields for pipeline files\n", commandName())
+	fmt.Fprintf(w, "\nFlags:\n")
+	newFlags(&options{}).PrintDefaults()
+}
+
+func commandName() string {
+	return path.Base(strings.Replace(os.Args[0], "\\", "/", -1))
+}
+
+func list(w io.Writer, pipelines ci.Pipelines) int {
+	for _, pipeline := range pipelines {
+		if pipeline.Desc != "" {
+			fmt.Fprintf(w, "%s\t%s\n", pipeline.Name, pipeline.Desc)
+		} else {
+			fmt.Fprintf(w, "%s\n", pipeline.Name)
+		}
+	}
+	return ExitSuccess
+}
+
+func steps(w io.Writer) int {
+	for _, kind := range ci.StepKinds() {
+		fields, _ := config.Fields(kind)
+		fmt.Fprintf(w, "%s\t%s\n", kind, strings.Join(fields, ", "))
+	}
+	return ExitSuccess
+}
+
+// options contains the flags for run and show.
+type options struct {
+	parallel      int
+	timeout       time.Duration
+	cleanup       time.Duration
+	only          stringList
+	skip          stringList
+	env           stringList
+	keepTemp      bool
+	keepArtifacts bool
+	keepPrepare   bool
+	dryRun        bool
+	format        string
+	listen        string
+	journal       bool
+	resume        string
+	junit         string
+}
+
+func newFlags(opts *options) *flag.FlagSet {
+	flags := flag.NewFlagSet(commandName(), flag.ContinueOnError)
+	flags.IntVar(&opts.parallel, "parallel", 0, "maximum number of concurrently running tasks, 0 means unlimited")
+	flags.DurationVar(&opts.timeout, "timeout", 0, "timeout for the whole pipeline")
+	flags.DurationVar(&opts.cleanup, "cleanup-timeout", ci.DefaultCleanupTimeout, "timeout for the finally tasks after the run is interrupted, 0 means unlimited")
+	flags.Var(&opts.only, "only", "run only tasks matching the path `pattern`, can be repeated")
+	flags.Var(&opts.skip, "skip", "skip tasks matching the path `pattern`, can be repeated")
+	flags.Var(&opts.env, "env", "set environment variable `KEY=VALUE`, can be repeated")
+	flags.BoolVar(&opts.keepPrepare, "keep-setup", true, "keep setup steps, such as SetEnv and CreateTempDir, next to the selected tasks")
+	flags.BoolVar(&opts.keepTemp, "keep-temp", false, "keep temporary directory after the run")
+	flags.BoolVar(&opts.keepArtifacts, "keep-artifacts", false, "keep the artifacts directory after the run")
+	flags.BoolVar(&opts.dryRun, "dry-run", false, "show what commands and file operations would do, without running them")
+	flags.StringVar(&opts.format, "format", "text", "output format: text or json")
+	flags.StringVar(&opts.listen, "listen", "", "serve a dashboard on the `address`")
+	flags.StringVar(&opts.junit, "junit", "", "write a JUnit XML report to the `file`")
+	flags.BoolVar(&opts.journal, "journal", false, "record the run state and keep the temporary directory of a failed run, so that it can be resumed")
+	flags.StringVar(&opts.resume, "resume", "", "resume the failed run with the `id`, recorded with -journal, skipping tasks that succeeded")
+	return flags
+}
+
+// parseFlags parses args with flags, allowing flags after the positional arguments,
+// and returns the positional arguments.
+func parseFlags(flags *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+func run(args []string, pipelines ci.Pipelines, showOnly bool) int {
+	var opts options
+	flags := newFlags(&opts)
+	names, err := parseFlags(flags, args)
+	if err != nil {
+		if err == flag.ErrHelp {
+			return ExitSuccess
+		}
+		return ExitUsage
+	}
+	if len(names) > 1 {
+		fmt.Fprintf(os.Stderr, "expected a single pipeline name, got %q\n", names)
+		return ExitUsage
+	}
+	if opts.format != "text" && opts.format != "json" {
+		fmt.Fprintf(os.Stderr, "unknown format %q\n", opts.format)
+		return ExitUsage
+	}
+
+	var pipelineName string
+	if len(names) > 0 {
+		pipelineName = names[0]
+	}
+
+	var journal *ci.Journal
+	if opts.resume != "" {
+		var err error
+		journal, err = ci.OpenJournal(opts.resume)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to resume: %v\n", err)
+			return ExitUsage
+		}
+		if pipelineName == "" {
+			pipelineName = journal.Pipeline()
+		} else if !strings.EqualFold(pipelineName, journal.Pipeline()) {
+			fmt.Fprintf(os.Stderr, "run %q is of pipeline %q, not %q\n", opts.resume, journal.Pipeline(), pipelineName)
+			return ExitUsage
+		}
+	}
+
+	if pipelineName == "" {
+		pipelineName = "Default"
+	}
+
+	pipeline, ok := pipelines.Find(pipelineName)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "did not find pipeline named %q\n", pipelineName)
+		return ExitUsage
+	}
+
+	task := pipeline.Task()
+	if opts.timeout > 0 {
+		task.Timeout = opts.timeout
+	}
+	if err := task.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid pipeline %q: %v\n", pipelineName, err)
+		return ExitUsage
+	}
+	if err := task.Filter(opts.only, opts.skip, opts.keepPrepare); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitUsage
+	}
+
+	if showOnly {
+		task.PrintTo(os.Stdout, "")
+		return ExitSuccess
+	}
+
+	return execute(task, journal, &opts)
+}
+
+// execute runs the task, journal is the state of the resumed run or nil.
+func execute(task *ci.Task, journal *ci.Journal, opts *options) int {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// interrupts are handled once the global context exists
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+
+	var logger ci.Logger
+	var renderer *term.Renderer
+	var observers ci.Observers
+
+	switch opts.format {
+	case "json":
+		logger = ci.NewStdOutput(os.Stderr, os.Stderr)
+		observers = append(observers, ci.NewJSONObserver(os.Stdout))
+	case "text":
+		renderer = term.New(os.Stdout, task)
+		if renderer.Interactive {
+			logger = ci.NewStdOutput(renderer, renderer)
+		}
+	}
+
+	if opts.listen != "" {
+		dashboard := web.New(task)
+		observers = append(observers, dashboard)
+		go func() {
+			if err := http.ListenAndServe(opts.listen, dashboard); err != nil {
+				fmt.Fprintf(os.Stderr, "dashboard failed: %v\n", err)
+			}
+		}()
+	}
+
+	var globalContext *ci.GlobalContext
+	var err error
+	if journal != nil {
+		globalContext, err = ci.ResumeGlobalContext(ctx, ".", journal, logger)
+	} else {
+		globalContext, err = ci.NewGlobalContext(ctx, ".", logger)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create context: %v\n", err)
+		return ExitFailure
+	}
+	if journal == nil && opts.journal && !opts.dryRun {
+		globalContext.EnableJournal(task.Name)
+	}
+
+	interrupted := make(chan struct{})
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-interrupt:
+			close(interrupted)
+			cancel()
+		case <-finished:
+			return
+		}
+
+		// commands run in their own process groups and do not receive the interrupt,
+		// so a second interrupt kills them without waiting for the cleanup
+		select {
+		case <-interrupt:
+			fmt.Fprintf(os.Stderr, "killing running commands\n")
+			globalContext.KillProcesses()
+			os.Exit(ExitInterrupted)
+		case <-finished:
+		}
+	}()
+
+	globalContext.MaxParallel = opts.parallel
+	globalContext.CleanupTimeout = opts.cleanup
+	globalContext.DryRun = opts.dryRun
+	globalContext.KeepArtifacts = opts.keepArtifacts
+	globalContext.Quiet = opts.format == "json" || (renderer != nil && renderer.Interactive)
+	if len(observers) > 0 {
+		globalContext.Observer = observers
+	}
+	for _, env := range opts.env {
+		eq := strings.Index(env, "=")
+		if eq < 0 {
+			fmt.Fprintf(os.Stderr, "invalid -env %q, expected KEY=VALUE\n", env)
+			_ = globalContext.Cleanup()
+			return ExitUsage
+		}
+		globalContext.SetEnv(env[:eq], env[eq+1:])
+	}
+
+	rendered := make(chan struct{})
+	renderCtx, stopRender := context.WithCancel(context.Background())
+	go func() {
+		defer close(rendered)
+		if renderer != nil {
+			_ = renderer.Run(renderCtx)
+		}
+	}()
+
+	err = task.Run(&globalContext.Context)
+
+	stopRender()
+	<-rendered
+
+	resumable := err != nil && globalContext.Journal != nil && !opts.dryRun
+	if opts.keepTemp || resumable {
+		fmt.Fprintf(os.Stderr, "temporary directory kept in %q\n", globalContext.TempDir())
+	} else if err := globalContext.Cleanup(); err != nil {
+		fmt.Fprintf(os.Stderr, "cleanup failed: %v\n", err)
+	}
+
+	if renderer != nil && !renderer.Interactive {
+		task.PrintTo(os.Stdout, "")
+	}
+
+	if opts.junit != "" {
+		if err := writeJUnit(opts.junit, task); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to write JUnit report: %v\n", err)
+		}
+	}
+
+	if resumable {
+		fmt.Fprintf(os.Stderr, "resume with: %s run -resume %s\n", commandName(), globalContext.RunID())
+	}
+
+	select {
+	case <-interrupted:
+		fmt.Fprintf(os.Stderr, "run interrupted\n")
+		return ExitInterrupted
+	default:
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "run failed: %v\n", err)
+		return ExitFailure
+	}
+	fmt.Fprintf(os.Stderr, "run succeeded\n")
+	return ExitSuccess
+}
+
+func writeJUnit(path string, task *ci.Task) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := report.JUnit(file, task); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// stringList is a flag that can be repeated.
+type stringList []string
+
+func (list *stringList) String() string { return strings.Join(*list, ",") }
+
+func (list *stringList) Set(value string) error {
+	*list = append(*list, value)
+	return nil
+}
@@ -0,0 +1,15 @@
+// Command ci runs pipelines from a YAML or JSON file.
+//
+// The file is ci.yaml in the current directory, unless specified with -file:
+//
+//	ci -file pipelines.json run -parallel 4 Default
+//
+// Programs with custom steps can register them with ci.RegisterStep
+// and call cli.MainConfig the same way.
+package main
+
+import "github.com/loov/ci/cli"
+
+func main() {
+	cli.MainConfig("ci.yaml")
+}
@@ -0,0 +1,173 @@
+// Package config loads pipelines from YAML or JSON files.
+//
+// A file contains a list of pipelines, each step is a mapping
+// from the step kind to its fields:
+//
+//	pipelines:
+//	  - name: Default
+//	    timeout: 30m
+//	    steps:
+//	      - stage:
+//	          name: Build
+//	          steps:
+//	            - run: {command: go, args: [build, ./...]}
+//	            - set-env: {env: GOOS, value: linux}
+//
+// Field names are the lower camel case names of the Go fields,
+// durations use the time.ParseDuration format.
+// Custom steps are available after registering them with ci.RegisterStep.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/loov/ci"
+)
+
+// Fields returns the names of the fields of a registered step kind.
+func Fields(kind string) ([]string, bool) {
+	step, ok := ci.NewStep(kind)
+	if !ok {
+		return nil, false
+	}
+	return fieldNames(reflect.TypeOf(step).Elem()), true
+}
+
+// file is the top-level structure of a pipeline file.
+type file struct {
+	Pipelines ci.Pipelines
+}
+
+// Load reads pipelines from a YAML or JSON file.
+func Load(path string) (ci.Pipelines, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(path, data)
+}
+
+// Parse parses pipelines from YAML or JSON data,
+// name is used for describing the location of errors.
+func Parse(name string, data []byte) (ci.Pipelines, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, Errors{syntaxError(name, err)}
+	}
+
+	var result file
+	decoder := &decoder{name: name}
+	if len(root.Content) == 0 {
+		decoder.errorf(&root, "file is empty")
+	} else {
+		decoder.value(root.Content[0], valueOf(&result))
+	}
+	if len(decoder.errs) > 0 {
+		return nil, decoder.errs
+	}
+	return result.Pipelines, nil
+}
+
+// Marshal encodes pipelines as YAML.
+func Marshal(pipelines ci.Pipelines) ([]byte, error) {
+	node, err := encode(pipelines)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(node); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// MarshalJSON encodes pipelines as JSON.
+func MarshalJSON(pipelines ci.Pipelines) ([]byte, error) {
+	node, err := encode(pipelines)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := writeJSON(&buf, node); err != nil {
+		return nil, err
+	}
+
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, buf.Bytes(), "", "\t"); err != nil {
+		return nil, err
+	}
+	indented.WriteByte('\n')
+	return indented.Bytes(), nil
+}
+
+// Save writes pipelines to path,
+// as JSON when the path has a .json extension and as YAML otherwise.
+func Save(path string, pipelines ci.Pipelines) error {
+	marshal := Marshal
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		marshal = MarshalJSON
+	}
+
+	data, err := marshal(pipelines)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// Error describes a problem at a location in a pipeline file.
+type Error struct {
+	File   string
+	Line   int
+	Column int
+	Msg    string
+}
+
+// Error returns the message prefixed with the location.
+func (err *Error) Error() string {
+	if err.Column > 0 {
+		return fmt.Sprintf("%s:%d:%d: %s", err.File, err.Line, err.Column, err.Msg)
+	}
+	return fmt.Sprintf("%s:%d: %s", err.File, err.Line, err.Msg)
+}
+
+// Errors contains all the problems found in a pipeline file.
+type Errors []*Error
+
+// Error returns all the error messages, one per line.
+func (errs Errors) Error() string {
+	messages := make([]string, 0, len(errs))
+	for _, err := range errs {
+		messages = append(messages, err.Error())
+	}
+	return strings.Join(messages, "\n")
+}
+
+var rxSyntaxError = regexp.MustCompile(`^yaml: line (\d+): (.*)$`)
+
+// syntaxError converts a YAML parsing error to Error.
+func syntaxError(name string, err error) *Error {
+	match := rxSyntaxError.FindStringSubmatch(err.Error())
+	if match == nil {
+		return &Error{File: name, Msg: strings.TrimPrefix(err.Error(), "yaml: ")}
+	}
+	line, _ := strconv.Atoi(match[1])
+	return &Error{File: name, Line: line, Msg: match[2]}
+}
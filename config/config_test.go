@@ -0,0 +1,203 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/loov/ci"
+)
+
+// canonical is a pipeline file in the format written by Marshal.
+const canonical = `pipelines:
+  - name: Default
+    desc: builds everything
+    timeout: 30m0s
+    steps:
+      - set-env: {global: true, env: GOFLAGS, value: -mod=readonly}
+      - stage:
+          name: Test
+          parallel: true
+          retry:
+            count: 2
+            backoff: {initial: 1s, max: 1m0s}
+          steps:
+            - run: {command: go, args: [vet, ./...]}
+            - run:
+                command: go
+                args: [test, ./...]
+                retry: {count: 3, backoff: 5s}
+      - matrix:
+          vars: {GOOS: [linux, windows]}
+          include:
+            - {GOOS: darwin}
+          exclude:
+            - {GOOS: windows}
+          steps:
+            - run: {command: go, args: [build]}
+      - stage:
+          name: Cleanup
+          when: always
+          needs: [Test]
+          steps:
+            - remove: {glob: tmp}
+`
+
+func TestRoundTrip(t *testing.T) {
+	pipelines, err := Parse("ci.yaml", []byte(canonical))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := Marshal(pipelines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != canonical {
+		t.Errorf("YAML round-trip changed the file:\n%s", data)
+	}
+
+	jsonData, err := MarshalJSON(pipelines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromJSON, err := Parse("ci.json", jsonData)
+	if err != nil {
+		t.Fatalf("parsing JSON: %v\n%s", err, jsonData)
+	}
+	data, err = Marshal(fromJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != canonical {
+		t.Errorf("JSON round-trip changed the file:\n%s", data)
+	}
+}
+
+func TestParseValues(t *testing.T) {
+	pipelines, err := Parse("ci.yaml", []byte(canonical))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pipelines) != 1 {
+		t.Fatalf("got %d pipelines, expected 1", len(pipelines))
+	}
+
+	pipeline := pipelines[0]
+	if pipeline.Name != "Default" || pipeline.Timeout != 30*time.Minute || len(pipeline.Steps) != 4 {
+		t.Errorf("got %q with timeout %v and %d steps", pipeline.Name, pipeline.Timeout, len(pipeline.Steps))
+	}
+
+	test, ok := pipeline.Steps[1].(*ci.Stage)
+	if !ok {
+		t.Fatalf("got %T, expected *ci.Stage", pipeline.Steps[1])
+	}
+	if !test.Parallel || test.Retry.Count != 2 || test.Retry.Delay(3) != 4*time.Second || test.Retry.Delay(10) != time.Minute {
+		t.Errorf("got parallel %v, retry %v, expected an exponential backoff", test.Parallel, test.Retry.Count)
+	}
+
+	run, ok := test.Steps[1].(*ci.Run)
+	if !ok {
+		t.Fatalf("got %T, expected *ci.Run", test.Steps[1])
+	}
+	if run.Command != "go" || strings.Join(run.Args, " ") != "test ./..." || run.Retry.Delay(2) != 5*time.Second {
+		t.Errorf("got %q %q with delay %v", run.Command, run.Args, run.Retry.Delay(2))
+	}
+
+	cleanup := pipeline.Steps[3].(*ci.Stage)
+	if cleanup.When != ci.Always || strings.Join(cleanup.Needs, ",") != "Test" {
+		t.Errorf("got when %v, needs %v", cleanup.When, cleanup.Needs)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		expected []string
+	}{
+		{
+			name:     "empty",
+			data:     ``,
+			expected: []string{"ci.yaml:0: file is empty"},
+		},
+		{
+			name:     "syntax",
+			data:     "pipelines:\n  - name: [\n",
+			expected: []string{"ci.yaml:2: did not find expected node content"},
+		},
+		{
+			name: "unknown step",
+			data: `pipelines:
+  - name: Default
+    steps:
+      - compile: {}
+`,
+			expected: []string{`ci.yaml:4:9: unknown step kind "compile", expected one of: `},
+		},
+		{
+			name: "unknown field",
+			data: `pipelines:
+  - name: Default
+    steps:
+      - run: {command: go, arguments: [test]}
+`,
+			expected: []string{`ci.yaml:4:28: unknown field "arguments" of run, expected one of: command, args, retry`},
+		},
+		{
+			name: "multiple",
+			data: `pipelines:
+  - name: Default
+    timeout: soon
+    steps:
+      - stage:
+          name: Test
+          when: sometimes
+          parallel: maybe
+          steps:
+            - run: {command: go, command: vet}
+`,
+			expected: []string{
+				`ci.yaml:3:14: expected a duration, such as 1m30s, got "soon"`,
+				`ci.yaml:7:17: unknown condition "sometimes", expected on-success, on-failure or always`,
+				`ci.yaml:8:21: expected a boolean, got "maybe"`,
+				`ci.yaml:10:34: duplicate field "command"`,
+			},
+		},
+		{
+			name: "wrong kind",
+			data: `pipelines:
+  - name: Default
+    steps: run
+`,
+			expected: []string{"ci.yaml:3:12: expected a list"},
+		},
+		{
+			name: "backoff",
+			data: `pipelines:
+  - name: Default
+    steps:
+      - run: {command: go, retry: {count: 1, backoff: [1s]}}
+`,
+			expected: []string{"ci.yaml:4:55: expected a duration or {initial, max}"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Parse("ci.yaml", []byte(test.data))
+			errs, ok := err.(Errors)
+			if !ok {
+				t.Fatalf("got %v, expected Errors", err)
+			}
+			if len(errs) != len(test.expected) {
+				t.Fatalf("got %d errors, expected %d:\n%v", len(errs), len(test.expected), errs)
+			}
+			for i, err := range errs {
+				if !strings.HasPrefix(err.Error(), test.expected[i]) {
+					t.Errorf("got %q, expected %q", err.Error(), test.expected[i])
+				}
+			}
+		})
+	}
+}
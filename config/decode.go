@@ -0,0 +1,269 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/loov/ci"
+)
+
+var (
+	stepType      = reflect.TypeOf((*ci.Step)(nil)).Elem()
+	durationType  = reflect.TypeOf(time.Duration(0))
+	conditionType = reflect.TypeOf(ci.OnSuccess)
+	backoffType   = reflect.TypeOf(ci.Backoff(nil))
+)
+
+var conditions = map[ci.Condition]string{
+	ci.OnSuccess: "on-success",
+	ci.OnFailure: "on-failure",
+	ci.Always:    "always",
+}
+
+// decoder fills values from YAML nodes and collects the errors.
+type decoder struct {
+	name string
+	errs Errors
+}
+
+func (decoder *decoder) errorf(node *yaml.Node, format string, args ...interface{}) {
+	decoder.errs = append(decoder.errs, &Error{
+		File:   decoder.name,
+		Line:   node.Line,
+		Column: node.Column,
+		Msg:    fmt.Sprintf(format, args...),
+	})
+}
+
+// valueOf returns the settable value that ptr points to.
+func valueOf(ptr interface{}) reflect.Value { return reflect.ValueOf(ptr).Elem() }
+
+// value decodes node into v.
+func (decoder *decoder) value(node *yaml.Node, v reflect.Value) {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!null" {
+		return
+	}
+
+	switch v.Type() {
+	case stepType:
+		if step := decoder.step(node); step != nil {
+			v.Set(reflect.ValueOf(step))
+		}
+		return
+	case durationType:
+		if !decoder.scalar(node, "a duration") {
+			return
+		}
+		duration, err := time.ParseDuration(node.Value)
+		if err != nil {
+			decoder.errorf(node, "expected a duration, such as 1m30s, got %q", node.Value)
+			return
+		}
+		v.SetInt(int64(duration))
+		return
+	case conditionType:
+		if !decoder.scalar(node, "a condition") {
+			return
+		}
+		for condition, name := range conditions {
+			if strings.EqualFold(node.Value, name) {
+				v.SetInt(int64(condition))
+				return
+			}
+		}
+		decoder.errorf(node, "unknown condition %q, expected on-success, on-failure or always", node.Value)
+		return
+	case backoffType:
+		decoder.backoff(node, v)
+		return
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		if decoder.scalar(node, "a string") {
+			v.SetString(node.Value)
+		}
+	case reflect.Bool:
+		if !decoder.scalar(node, "a boolean") {
+			return
+		}
+		var value bool
+		if err := node.Decode(&value); err != nil {
+			decoder.errorf(node, "expected a boolean, got %q", node.Value)
+			return
+		}
+		v.SetBool(value)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if !decoder.scalar(node, "an integer") {
+			return
+		}
+		value, err := strconv.ParseInt(node.Value, 0, v.Type().Bits())
+		if err != nil {
+			decoder.errorf(node, "expected an integer, got %q", node.Value)
+			return
+		}
+		v.SetInt(value)
+	case reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			decoder.errorf(node, "expected a list")
+			return
+		}
+		slice := reflect.MakeSlice(v.Type(), len(node.Content), len(node.Content))
+		for i, item := range node.Content {
+			decoder.value(item, slice.Index(i))
+		}
+		v.Set(slice)
+	case reflect.Map:
+		if node.Kind != yaml.MappingNode || v.Type().Key().Kind() != reflect.String {
+			decoder.errorf(node, "expected a mapping")
+			return
+		}
+		m := reflect.MakeMapWithSize(v.Type(), len(node.Content)/2)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			elem := reflect.New(v.Type().Elem()).Elem()
+			decoder.value(value, elem)
+			m.SetMapIndex(reflect.ValueOf(key.Value).Convert(v.Type().Key()), elem)
+		}
+		v.Set(m)
+	case reflect.Ptr:
+		elem := reflect.New(v.Type().Elem())
+		decoder.value(node, elem.Elem())
+		v.Set(elem)
+	case reflect.Struct:
+		decoder.fields(node, v, "")
+	default:
+		decoder.errorf(node, "unsupported type %v", v.Type())
+	}
+}
+
+// scalar checks whether node is a scalar.
+func (decoder *decoder) scalar(node *yaml.Node, expected string) bool {
+	if node.Kind != yaml.ScalarNode {
+		decoder.errorf(node, "expected %s", expected)
+		return false
+	}
+	return true
+}
+
+// step decodes a mapping from the step kind to its fields.
+func (decoder *decoder) step(node *yaml.Node) ci.Step {
+	if node.Kind != yaml.MappingNode || len(node.Content) != 2 {
+		decoder.errorf(node, "expected a step, such as `run: {command: go, args: [test]}`")
+		return nil
+	}
+
+	kind, fields := node.Content[0], node.Content[1]
+	step, ok := ci.NewStep(kind.Value)
+	if !ok {
+		decoder.errorf(kind, "unknown step kind %q, expected one of: %s", kind.Value, strings.Join(ci.StepKinds(), ", "))
+		return nil
+	}
+
+	decoder.fields(fields, reflect.ValueOf(step).Elem(), kind.Value)
+	return step
+}
+
+// fields decodes a mapping into the fields of struct v,
+// kind is used to describe v in the errors.
+func (decoder *decoder) fields(node *yaml.Node, v reflect.Value, kind string) {
+	if kind == "" {
+		kind = strings.ToLower(v.Type().Name())
+	}
+	if node.Kind != yaml.MappingNode {
+		decoder.errorf(node, "expected the fields of %s", kind)
+		return
+	}
+
+	seen := map[string]bool{}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		index, ok := fieldIndex(v.Type(), key.Value)
+		if !ok {
+			decoder.errorf(key, "unknown field %q of %s, expected one of: %s", key.Value, kind, strings.Join(fieldNames(v.Type()), ", "))
+			continue
+		}
+		if seen[key.Value] {
+			decoder.errorf(key, "duplicate field %q", key.Value)
+			continue
+		}
+		seen[key.Value] = true
+		decoder.value(value, v.Field(index))
+	}
+}
+
+// backoff decodes a duration as a fixed backoff and
+// a mapping with initial and max as an exponential backoff.
+func (decoder *decoder) backoff(node *yaml.Node, v reflect.Value) {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		var delay time.Duration
+		decoder.value(node, valueOf(&delay))
+		v.Set(reflect.ValueOf(ci.FixedBackoff(delay)))
+	case yaml.MappingNode:
+		var exponential struct{ Initial, Max time.Duration }
+		decoder.fields(node, valueOf(&exponential), "backoff")
+		v.Set(reflect.ValueOf(ci.ExponentialBackoff(exponential.Initial, exponential.Max)))
+	default:
+		decoder.errorf(node, "expected a duration or {initial, max}")
+	}
+}
+
+// fieldIndex finds the configurable field of struct t with the name.
+func fieldIndex(t reflect.Type, name string) (int, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if configurable(field) && fieldName(field.Name) == name {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// fieldNames returns the names of the configurable fields of struct t.
+func fieldNames(t reflect.Type) []string {
+	var names []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if configurable(field) {
+			names = append(names, fieldName(field.Name))
+		}
+	}
+	return names
+}
+
+// configurable returns whether the field can be set from a file.
+func configurable(field reflect.StructField) bool {
+	if field.PkgPath != "" {
+		return false
+	}
+	switch field.Type.Kind() {
+	case reflect.Func:
+		return field.Type == backoffType
+	case reflect.Chan, reflect.UnsafePointer, reflect.Complex64, reflect.Complex128:
+		return false
+	}
+	return true
+}
+
+// fieldName converts a Go field name to lower camel case,
+// e.g. SourceGlob to sourceGlob and URLPath to urlPath.
+func fieldName(name string) string {
+	runes := []rune(name)
+	for i := 0; i < len(runes) && unicode.IsUpper(runes[i]); i++ {
+		if i > 0 && i+1 < len(runes) && unicode.IsLower(runes[i+1]) {
+			break
+		}
+		runes[i] = unicode.ToLower(runes[i])
+	}
+	return string(runes)
+}
@@ -0,0 +1,230 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/loov/ci"
+)
+
+// encode converts pipelines to a YAML document.
+func encode(pipelines ci.Pipelines) (*yaml.Node, error) {
+	node, err := encodeValue(valueOf(&file{Pipelines: pipelines}))
+	if err != nil {
+		return nil, err
+	}
+	return &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{node}}, nil
+}
+
+// encodeValue converts v to a YAML node.
+func encodeValue(v reflect.Value) (*yaml.Node, error) {
+	switch v.Type() {
+	case stepType:
+		return encodeStep(v.Interface().(ci.Step))
+	case durationType:
+		return scalar("!!str", time.Duration(v.Int()).String()), nil
+	case conditionType:
+		name, ok := conditions[ci.Condition(v.Int())]
+		if !ok {
+			return nil, fmt.Errorf("unknown condition %d", v.Int())
+		}
+		return scalar("!!str", name), nil
+	case backoffType:
+		return encodeBackoff(v.Interface().(ci.Backoff))
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return scalar("!!str", v.String()), nil
+	case reflect.Bool:
+		return scalar("!!bool", strconv.FormatBool(v.Bool())), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return scalar("!!int", strconv.FormatInt(v.Int(), 10)), nil
+	case reflect.Slice:
+		node := &yaml.Node{Kind: yaml.SequenceNode}
+		for i := 0; i < v.Len(); i++ {
+			item, err := encodeValue(v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			node.Content = append(node.Content, item)
+		}
+		node.Style = flowStyle(node.Content)
+		return node, nil
+	case reflect.Map:
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, k int) bool { return keys[i].String() < keys[k].String() })
+		node := &yaml.Node{Kind: yaml.MappingNode}
+		for _, key := range keys {
+			value, err := encodeValue(v.MapIndex(key))
+			if err != nil {
+				return nil, err
+			}
+			node.Content = append(node.Content, scalar("!!str", key.String()), value)
+		}
+		node.Style = flowStyle(node.Content)
+		return node, nil
+	case reflect.Ptr:
+		if v.IsNil() {
+			return scalar("!!null", "null"), nil
+		}
+		return encodeValue(v.Elem())
+	case reflect.Struct:
+		return encodeFields(v)
+	}
+	return nil, fmt.Errorf("unsupported type %v", v.Type())
+}
+
+// encodeStep converts step to a mapping from its kind to the fields.
+func encodeStep(step ci.Step) (*yaml.Node, error) {
+	kind, ok := ci.StepKind(step)
+	if !ok {
+		return nil, fmt.Errorf("step type %T is not registered with ci.RegisterStep", step)
+	}
+
+	fields, err := encodeFields(reflect.ValueOf(step).Elem())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", kind, err)
+	}
+	return &yaml.Node{
+		Kind:    yaml.MappingNode,
+		Content: []*yaml.Node{scalar("!!str", kind), fields},
+	}, nil
+}
+
+// encodeFields converts the non-empty configurable fields of struct v to a mapping.
+func encodeFields(v reflect.Value) (*yaml.Node, error) {
+	node := &yaml.Node{Kind: yaml.MappingNode}
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if !configurable(field) || isEmpty(v.Field(i)) {
+			continue
+		}
+
+		value, err := encodeValue(v.Field(i))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", fieldName(field.Name), err)
+		}
+		node.Content = append(node.Content, scalar("!!str", fieldName(field.Name)), value)
+	}
+	node.Style = flowStyle(node.Content)
+	return node, nil
+}
+
+// encodeBackoff recognizes backoffs created by
+// ci.FixedBackoff and ci.ExponentialBackoff from their delays.
+func encodeBackoff(backoff ci.Backoff) (*yaml.Node, error) {
+	const samples = 64
+
+	initial, max := backoff(1), backoff(1)
+	for n := 2; n <= samples; n++ {
+		if delay := backoff(n); delay > max {
+			max = delay
+		}
+	}
+	if initial == max {
+		return scalar("!!str", initial.String()), nil
+	}
+
+	exponential := ci.ExponentialBackoff(initial, max)
+	for n := 1; n <= samples; n++ {
+		if backoff(n) != exponential(n) {
+			return nil, fmt.Errorf("only fixed and exponential backoffs can be encoded")
+		}
+	}
+	return encodeFields(valueOf(&struct{ Initial, Max time.Duration }{initial, max}))
+}
+
+func scalar(tag, value string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: value}
+}
+
+// flowStyle returns the flow style for nodes that only contain scalars and
+// short lists of scalars, e.g. `{command: go, args: [test, ./...]}`.
+func flowStyle(content []*yaml.Node) yaml.Style {
+	const maxItems = 8
+	if len(content) == 0 || len(content) > maxItems {
+		return 0
+	}
+	for _, node := range content {
+		if node.Kind == yaml.ScalarNode {
+			continue
+		}
+		if node.Style != yaml.FlowStyle || node.Kind != yaml.SequenceNode {
+			return 0
+		}
+	}
+	return yaml.FlowStyle
+}
+
+// isEmpty returns whether v has the zero value or is an empty collection.
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	case reflect.Func, reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if !isEmpty(v.Field(i)) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(v.Interface(), reflect.Zero(v.Type()).Interface())
+}
+
+// writeJSON writes the YAML node as JSON, keeping the order of the fields.
+func writeJSON(buf *bytes.Buffer, node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		return writeJSON(buf, node.Content[0])
+	case yaml.MappingNode:
+		buf.WriteByte('{')
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeJSON(buf, node.Content[i]); err != nil {
+				return err
+			}
+			buf.WriteByte(':')
+			if err := writeJSON(buf, node.Content[i+1]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	case yaml.SequenceNode:
+		buf.WriteByte('[')
+		for i, item := range node.Content {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeJSON(buf, item); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case yaml.ScalarNode:
+		if node.Tag != "!!str" {
+			buf.WriteString(node.Value)
+			return nil
+		}
+		data, err := json.Marshal(node.Value)
+		if err != nil {
+			return err
+		}
+		buf.Write(data)
+	default:
+		return fmt.Errorf("unsupported node kind %v", node.Kind)
+	}
+	return nil
+}
@@ -1,36 +1,94 @@
 package ci
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/semaphore"
 )
 
+// Logger defines a hierarchical leveled logger
 type Logger interface {
+	// Named creates a logger for a nested task
 	Named(name string) Logger
-	// Output() (stdout, stderr io.Writer)
+	// With creates a logger that adds a field to every message
+	With(key string, value interface{}) Logger
+	// Output returns writers for command output,
+	// writers with a `Flush() error` method are flushed after the command finishes
+	Output() (stdout, stderr io.Writer)
 
+	// Print and Printf log at info level
 	Print(v ...interface{})
 	Printf(format string, v ...interface{})
 
+	Debug(v ...interface{})
+	Debugf(format string, v ...interface{})
+
+	Info(v ...interface{})
+	Infof(format string, v ...interface{})
+
+	Warn(v ...interface{})
+	Warnf(format string, v ...interface{})
+
 	Error(v ...interface{})
 	Errorf(format string, v ...interface{})
 }
 
+// DefaultCleanupTimeout is the default GlobalContext.CleanupTimeout.
+const DefaultCleanupTimeout = time.Minute
+
 // GlobalContext defines the global execution context
 type GlobalContext struct {
 	// ScriptDir is the script location
 	ScriptDir string
 	// GEnv is the global environment variables
 	GEnv Env
+	// Quiet disables echoing command output to the terminal,
+	// the output is still captured by the tasks
+	Quiet bool
+	// Observer receives task lifecycle events
+	Observer Observer
+	// CacheDir is the directory for cached step results
+	CacheDir string
+	// Artifacts stores files published by Artifact steps
+	Artifacts ArtifactStore
+	// KeepArtifacts keeps the default artifact directory on Cleanup
+	KeepArtifacts bool
+	// DryRun describes what commands and file operations would do, without doing them
+	DryRun bool
+	// MaxParallel limits the number of concurrently running leaf tasks, 0 means unlimited
+	MaxParallel int
+	// Journal records the run state after each task, nil disables recording
+	Journal *Journal
+	// CleanupTimeout limits the tasks that always run after the run was cancelled,
+	// 0 means no limit
+	CleanupTimeout time.Duration
 
 	Context
 
+	limit struct {
+		once sync.Once
+		sem  *semaphore.Weighted
+	}
+
+	// processes are the running commands
+	processes struct {
+		mu      sync.Mutex
+		killed  bool
+		running map[*exec.Cmd]struct{}
+	}
+
 	// TempDir defines the temporary working directory
 	temp struct {
 		root  string
@@ -42,25 +100,88 @@ type GlobalContext struct {
 // Sub creates a sub context
 func (context *Context) Sub(name string) *Context {
 	return &Context{
+		Context:    context.Context,
 		Global:     context.Global,
 		WorkingDir: context.WorkingDir,
 		Env:        context.Env.Clone(),
 		Logger:     context.Logger.Named(name),
+		limits:     context.limits,
 	}
 }
 
+// conditionContext returns the context for running a task with the condition
+// after failed has failed with errs, nil means the task must not run.
+//
+// Tasks that always run are detached from the cancellation of parent and
+// limited to CleanupTimeout instead, release must be called after the task finishes.
+// The failure is described in FAILED_TASK and FAILED_ERROR variables.
+func conditionContext(parent *Context, condition Condition, failed *Task, errs Errors) (sub *Context, release func()) {
+	release = func() {}
+	switch condition {
+	case OnFailure:
+		if failed == nil || parent.Err() != nil {
+			return nil, release
+		}
+	case Always:
+	default:
+		return parent, release
+	}
+
+	sub = new(Context)
+	*sub = *parent
+	if condition == Always && parent.Err() != nil {
+		sub.Context = context.Background()
+		if timeout := parent.Global.CleanupTimeout; timeout > 0 {
+			sub.Context, release = context.WithTimeout(sub.Context, timeout)
+		}
+	}
+	if failed != nil {
+		sub.Env = parent.Env.Clone()
+		sub.Env.Set("FAILED_TASK", failed.Path())
+		sub.Env.Set("FAILED_ERROR", errs.Error())
+	}
+	return sub, release
+}
+
 // Context defines task execution context and environment variable management
+//
+// Context embeds context.Context, which is used for cancellation.
 type Context struct {
+	context.Context
+
 	Global     *GlobalContext
 	WorkingDir string
 	Env        Env
 
 	Logger
+
+	// limits are the semaphores of the enclosing limited parallel stages
+	limits []*semaphore.Weighted
+}
+
+// NewGlobalContext creates a new global context,
+// cancelling ctx stops the tasks running in it.
+func NewGlobalContext(ctx context.Context, scriptDir string, logger Logger) (*GlobalContext, error) {
+	return newGlobalContext(ctx, scriptDir, "", logger)
+}
+
+// ResumeGlobalContext creates a global context that continues the run recorded in journal,
+// the temporary directory and the global environment of the run are reused.
+func ResumeGlobalContext(ctx context.Context, scriptDir string, journal *Journal, logger Logger) (*GlobalContext, error) {
+	context, err := newGlobalContext(ctx, scriptDir, journal.Dir(), logger)
+	if err != nil {
+		return nil, err
+	}
+	context.GEnv = journal.State().GEnv
+	context.Journal = journal
+	return context, nil
 }
 
-// NewGlobalContext creates
-func NewGlobalContext(scriptDir string, logger Logger) (*GlobalContext, error) {
+// newGlobalContext creates a global context with temporary data in root,
+// a new directory is created when root is empty.
+func newGlobalContext(ctx context.Context, scriptDir, root string, logger Logger) (*GlobalContext, error) {
 	context := &GlobalContext{}
+	context.Context.Context = ctx
 	context.Global = context
 
 	context.Logger = logger
@@ -69,8 +190,9 @@ func NewGlobalContext(scriptDir string, logger Logger) (*GlobalContext, error) {
 	}
 
 	context.Env = os.Environ()
+	context.CleanupTimeout = DefaultCleanupTimeout
 
-	err := context.init()
+	err := context.init(root)
 	if err != nil {
 		return nil, err
 	}
@@ -83,6 +205,14 @@ func NewGlobalContext(scriptDir string, logger Logger) (*GlobalContext, error) {
 	context.ScriptDir = absScriptDir
 	context.SetEnv("SCRIPTDIR", context.ScriptDir)
 
+	context.Artifacts = NewFileArtifacts(context.ArtifactDir())
+
+	if userCache, err := os.UserCacheDir(); err == nil {
+		context.CacheDir = filepath.Join(userCache, "loov-ci")
+	} else {
+		context.CacheDir = filepath.Join(context.temp.root, "cache")
+	}
+
 	if runtime.GOOS == "windows" {
 		context.SetEnv("TEMP", context.temp.def)
 		context.SetEnv("TMP", context.temp.def)
@@ -93,42 +223,106 @@ func NewGlobalContext(scriptDir string, logger Logger) (*GlobalContext, error) {
 	return context, err
 }
 
-func (context *GlobalContext) init() error {
+func (context *GlobalContext) init(root string) error {
 	var err error
 
 	// create root temp directory
-	context.temp.root, err = ioutil.TempDir("", "ci")
-	if err != nil {
+	if root == "" {
+		root, err = ioutil.TempDir("", "ci")
+		if err != nil {
+			return err
+		}
+	} else if _, err := os.Stat(root); err != nil {
 		return err
 	}
+	context.temp.root = root
 
 	// create default temporary directory for commands
 	context.temp.def = filepath.Join(context.temp.root, "temp")
-	if err := os.Mkdir(context.temp.def, 0777); err != nil {
+	if err := os.Mkdir(context.temp.def, 0777); err != nil && !os.IsExist(err) {
 		return err
 	}
 
 	return nil
 }
 
+// observer returns the observer or a no-op observer.
+func (context *GlobalContext) observer() Observer {
+	if context.Observer == nil {
+		return NopObserver{}
+	}
+	return context.Observer
+}
+
 // SafePath checks whether glob can be changed
 func (context *GlobalContext) SafePath(glob string) error {
 	return nil
 }
 
-// CreateTempDir creates a temporary directory
+// CreateTempDir creates a temporary directory,
+// in dry-run mode only the path is returned
 func (context *GlobalContext) CreateTempDir(prefix string) string {
-	index := atomic.AddInt32(&context.temp.index, 1)
-	dir := filepath.Join(context.temp.root, prefix+"-"+strconv.Itoa(int(index)))
-	if err := os.Mkdir(dir, 0777); err != nil {
-		context.Errorf("failed to create nested temporary directory: %v", err)
+	for {
+		index := atomic.AddInt32(&context.temp.index, 1)
+		dir := filepath.Join(context.temp.root, prefix+"-"+strconv.Itoa(int(index)))
+		if context.DryRun {
+			return dir
+		}
+		err := os.Mkdir(dir, 0777)
+		if os.IsExist(err) {
+			// left over from the resumed run
+			continue
+		}
+		if err != nil {
+			context.Errorf("failed to create nested temporary directory: %v", err)
+		}
+		return dir
 	}
-	return dir
 }
 
-// Cleanup deletes all temporary data.
+// RunID returns the identifier of the run, used for resuming it.
+func (context *GlobalContext) RunID() string {
+	return filepath.Base(context.temp.root)
+}
+
+// EnableJournal starts recording the run state of pipeline,
+// so that the run can be resumed with ResumeGlobalContext.
+func (context *GlobalContext) EnableJournal(pipeline string) {
+	context.Journal = newJournal(filepath.Join(context.temp.root, journalName), pipeline)
+}
+
+// TempDir returns the root directory for temporary data.
+func (context *GlobalContext) TempDir() string {
+	return context.temp.root
+}
+
+// ArtifactDir returns the directory of the default artifact store.
+func (context *GlobalContext) ArtifactDir() string {
+	return filepath.Join(context.temp.root, "artifacts")
+}
+
+// Cleanup deletes all temporary data,
+// artifacts are kept when KeepArtifacts is set.
 func (context *GlobalContext) Cleanup() error {
-	return os.RemoveAll(context.temp.root)
+	if !context.KeepArtifacts {
+		return os.RemoveAll(context.temp.root)
+	}
+
+	infos, err := ioutil.ReadDir(context.temp.root)
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		path := filepath.Join(context.temp.root, info.Name())
+		if path == context.ArtifactDir() {
+			continue
+		}
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+	}
+	context.Printf("artifacts kept in %q", context.ArtifactDir())
+	return nil
 }
 
 // SetEnv changes environment variable value
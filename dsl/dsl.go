@@ -1,39 +1,187 @@
 package dsl
 
-import "github.com/loov/ci"
+import (
+	"strings"
+	"time"
+
+	"github.com/loov/ci"
+)
+
+// Option configures the Pipeline or Stage it is passed to among the steps.
+type Option struct {
+	Pipeline func(*ci.Pipeline)
+	Stage    func(*ci.Stage)
+	Matrix   func(*ci.Matrix)
+	Cached   func(*ci.Cached)
+}
+
+// Setup does nothing, options are applied by the step they are passed to.
+func (option *Option) Setup(parent *ci.Task) {}
+
+func splitOptions(steps []ci.Step) (options []*Option, rest []ci.Step) {
+	for _, step := range steps {
+		if option, ok := step.(*Option); ok {
+			options = append(options, option)
+		} else {
+			rest = append(rest, step)
+		}
+	}
+	return options, rest
+}
 
 func Pipelines(pipelines ...*ci.Pipeline) ci.Pipelines {
 	return pipelines
 }
 
 func Pipeline(name, desc string, steps ...ci.Step) *ci.Pipeline {
-	return &ci.Pipeline{
+	options, steps := splitOptions(steps)
+	pipeline := &ci.Pipeline{
 		Name:  name,
 		Desc:  desc,
 		Steps: steps,
 	}
+	for _, option := range options {
+		if option.Pipeline != nil {
+			option.Pipeline(pipeline)
+		}
+	}
+	return pipeline
 }
 
 func Stage(name string, steps ...ci.Step) *ci.Stage {
-	return &ci.Stage{
-		Name:     name,
-		Parallel: false,
-		Steps:    steps,
-	}
+	return stage(name, false, steps)
 }
 
 func Parallel(name string, steps ...ci.Step) *ci.Stage {
-	return &ci.Stage{
+	return stage(name, true, steps)
+}
+
+func ParallelN(name string, n int, steps ...ci.Step) *ci.Stage {
+	stage := stage(name, true, steps)
+	stage.MaxParallel = n
+	return stage
+}
+
+func stage(name string, parallel bool, steps []ci.Step) *ci.Stage {
+	options, steps := splitOptions(steps)
+	stage := &ci.Stage{
 		Name:     name,
-		Parallel: true,
+		Parallel: parallel,
 		Steps:    steps,
 	}
+	for _, option := range options {
+		if option.Stage != nil {
+			option.Stage(stage)
+		}
+	}
+	return stage
+}
+
+func Timeout(timeout time.Duration, stage *ci.Stage) *ci.Stage {
+	stage.Timeout = timeout
+	return stage
+}
+
+func Retry(count int, backoff ci.Backoff, stage *ci.Stage) *ci.Stage {
+	stage.Retry = ci.Retry{Count: count, Backoff: backoff}
+	return stage
+}
+
+func AllowFailure(stage *ci.Stage) *ci.Stage {
+	stage.AllowFailure = true
+	return stage
+}
+
+func Finally(steps ...ci.Step) *ci.Stage {
+	stage := stage("finally", false, steps)
+	stage.When = ci.Always
+	return stage
+}
+
+func OnFailure(steps ...ci.Step) *ci.Stage {
+	stage := stage("on failure", false, steps)
+	stage.When = ci.OnFailure
+	return stage
+}
+
+func Needs(names ...string) *Option {
+	return &Option{
+		Stage: func(stage *ci.Stage) { stage.Needs = append(stage.Needs, names...) },
+	}
+}
+
+func ContinueOnError() *Option {
+	return &Option{
+		Stage: func(stage *ci.Stage) { stage.ContinueOnError = true },
+	}
+}
+
+func WithTimeout(timeout time.Duration) *Option {
+	return &Option{
+		Pipeline: func(pipeline *ci.Pipeline) { pipeline.Timeout = timeout },
+		Stage:    func(stage *ci.Stage) { stage.Timeout = timeout },
+	}
+}
+
+func Matrix(vars map[string][]string, steps ...ci.Step) *ci.Matrix {
+	options, steps := splitOptions(steps)
+	matrix := &ci.Matrix{
+		Vars:  vars,
+		Steps: steps,
+	}
+	for _, option := range options {
+		if option.Matrix != nil {
+			option.Matrix(matrix)
+		}
+	}
+	return matrix
+}
+
+func Include(values map[string]string) *Option {
+	return &Option{
+		Matrix: func(matrix *ci.Matrix) { matrix.Include = append(matrix.Include, values) },
+	}
+}
+
+func Exclude(values map[string]string) *Option {
+	return &Option{
+		Matrix: func(matrix *ci.Matrix) { matrix.Exclude = append(matrix.Exclude, values) },
+	}
+}
+
+func Cached(key string, inputs, outputs []string, steps ...ci.Step) *ci.Cached {
+	options, steps := splitOptions(steps)
+	cached := &ci.Cached{
+		Key:     key,
+		Inputs:  inputs,
+		Outputs: outputs,
+		Steps:   steps,
+	}
+	for _, option := range options {
+		if option.Cached != nil {
+			option.Cached(cached)
+		}
+	}
+	return cached
+}
+
+func CacheEnv(names ...string) *Option {
+	return &Option{
+		Cached: func(cached *ci.Cached) { cached.Env = append(cached.Env, names...) },
+	}
 }
 
 func Run(command string, args ...string) *ci.Run {
 	return &ci.Run{Command: command, Args: args}
 }
 
+func GoTest(pkgs string, flags ...string) *ci.GoTest {
+	return &ci.GoTest{
+		Packages: strings.Fields(pkgs),
+		Flags:    flags,
+	}
+}
+
 func SetEnv(name, value string) *ci.SetEnv {
 	return &ci.SetEnv{
 		Global: false,
@@ -88,3 +236,17 @@ func CreateGlobalTempDir(name string) *ci.CreateTempDir {
 		Env:    name,
 	}
 }
+
+func Artifact(name, glob string) *ci.Artifact {
+	return &ci.Artifact{
+		Name: name,
+		Glob: glob,
+	}
+}
+
+func UseArtifact(name, destination string) *ci.UseArtifact {
+	return &ci.UseArtifact{
+		Name:        name,
+		Destination: destination,
+	}
+}
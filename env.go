@@ -10,6 +10,7 @@ type SetEnv struct {
 // Setup sets up the step
 func (step *SetEnv) Setup(parent *Task) {
 	task := parent.Subtask("%v := %q", step.Env, step.Value)
+	task.Prepare = true
 	task.Exec = func(context, _ *Context) error {
 		value, err := context.ExpandEnv(step.Value)
 		if err != nil {
@@ -79,6 +80,7 @@ type CreateTempDir struct {
 // Setup sets up the step
 func (step *CreateTempDir) Setup(parent *Task) {
 	task := parent.Subtask("%v := tempdir", step.Env)
+	task.Prepare = true
 	task.Exec = func(context, _ *Context) error {
 		dir := context.Global.CreateTempDir(step.Env)
 		if step.Global {
@@ -31,12 +31,19 @@ func (step *Copy) Setup(parent *Task) {
 			return fmt.Errorf("glob not allowed in destination %q [expanded %q]", step.Destination, destination)
 		}
 
-		if err := os.MkdirAll(destination, 0777); err != nil && !os.IsExist(err) {
+		matches, err := filepath.Glob(source)
+		if err != nil {
 			return err
 		}
 
-		matches, err := filepath.Glob(source)
-		if err != nil {
+		if context.Global.DryRun {
+			for _, match := range matches {
+				context.Printf("would copy %q to %q", match, destination)
+			}
+			return nil
+		}
+
+		if err := os.MkdirAll(destination, 0777); err != nil && !os.IsExist(err) {
 			return err
 		}
 
@@ -73,6 +80,10 @@ func (step *Remove) Setup(parent *Task) {
 
 		matches, err := filepath.Glob(glob)
 		for _, match := range matches {
+			if context.Global.DryRun {
+				context.Printf("would remove %q", match)
+				continue
+			}
 			if err := safeRemove(match); err != nil {
 				return err
 			}
@@ -0,0 +1,117 @@
+package ci
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Filter prunes the tree to tasks whose path matches one of the include
+// patterns and none of the exclude patterns, an empty include selects everything.
+//
+// Patterns use path.Match syntax and match either the whole path or
+// a trailing part of it, e.g. "*/Lint" matches "Default/Verification/Lint".
+// Subtasks of a selected task are kept, unless excluded, and so are the ancestors.
+// When keepPrepare is set, the Prepare siblings of kept tasks are kept as well,
+// so that SetEnv and CreateTempDir still run for the selected tasks.
+//
+// An include pattern that matches no task is an error,
+// so that a mistyped filter does not silently run nothing.
+func (task *Task) Filter(include, exclude []string, keepPrepare bool) error {
+	for _, pattern := range append(append([]string{}, include...), exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %v", pattern, err)
+		}
+	}
+	if len(include) == 0 && len(exclude) == 0 {
+		return nil
+	}
+
+	for _, pattern := range include {
+		if !task.matchesAny(pattern) {
+			return fmt.Errorf("pattern %q does not match any task", pattern)
+		}
+	}
+
+	if !task.filter(include, exclude, keepPrepare, false) {
+		return fmt.Errorf("all the selected tasks are skipped")
+	}
+	return nil
+}
+
+// matchesAny checks whether pattern matches task or any of its descendants.
+func (task *Task) matchesAny(pattern string) bool {
+	if MatchPath([]string{pattern}, task.Path()) {
+		return true
+	}
+	for _, subtask := range task.Tasks {
+		if subtask.matchesAny(pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// filter prunes the subtasks and reports whether task should be kept.
+func (task *Task) filter(include, exclude []string, keepPrepare, selected bool) bool {
+	taskPath := task.Path()
+	if MatchPath(exclude, taskPath) {
+		return false
+	}
+	selected = selected || len(include) == 0 || MatchPath(include, taskPath)
+
+	keep := make([]bool, len(task.Tasks))
+	anyKept := false
+	for i, subtask := range task.Tasks {
+		keep[i] = subtask.filter(include, exclude, keepPrepare, selected)
+		anyKept = anyKept || keep[i]
+	}
+
+	var tasks []*Task
+	for i, subtask := range task.Tasks {
+		prepare := keepPrepare && anyKept && subtask.Prepare && !MatchPath(exclude, subtask.Path())
+		if keep[i] || prepare {
+			tasks = append(tasks, subtask)
+		}
+	}
+
+	kept := map[string]bool{}
+	for _, subtask := range tasks {
+		kept[subtask.Name] = true
+	}
+	// dependencies that were removed are treated as satisfied
+	for _, subtask := range tasks {
+		var needs []string
+		for _, need := range subtask.Needs {
+			if kept[need] {
+				needs = append(needs, need)
+			}
+		}
+		subtask.Needs = needs
+	}
+
+	task.Tasks = tasks
+	return selected || len(tasks) > 0
+}
+
+// MatchPath checks whether any of the patterns matches taskPath or a trailing part of it.
+//
+// Path segments are task names, where "%" is escaped as "%25" and "/" as "%2F",
+// e.g. `run "go vet ./..."` is `run "go vet .%2F..."` in the path,
+// so that `Test/run*` matches it as a single segment.
+func MatchPath(patterns []string, taskPath string) bool {
+	for _, pattern := range patterns {
+		suffix := taskPath
+		for {
+			if ok, _ := path.Match(pattern, suffix); ok {
+				return true
+			}
+			slash := strings.Index(suffix, "/")
+			if slash < 0 {
+				break
+			}
+			suffix = suffix[slash+1:]
+		}
+	}
+	return false
+}
@@ -0,0 +1,172 @@
+package ci
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMatchPath(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		path     string
+		expected bool
+	}{
+		{[]string{"Default"}, "Default", true},
+		{[]string{"Lint"}, "Default/Verification/Lint", true},
+		{[]string{"*/Lint"}, "Default/Verification/Lint", true},
+		{[]string{"Verification/*"}, "Default/Verification/Lint", true},
+		{[]string{"Verification"}, "Default/Verification/Lint", false},
+		{[]string{"Lint", "Build"}, "Default/Build", true},
+		{[]string{"Test/run*"}, `Default/Test/run "go vet .%2F..."`, true},
+		{[]string{`run "go vet*`}, `Default/Test/run "go vet .%2F..."`, true},
+		{[]string{"run*/..."}, `Default/Test/run "go vet .%2F..."`, false},
+		{[]string{"Test#2"}, "Default/Test#2", true},
+		{nil, "Default", false},
+	}
+
+	for _, test := range tests {
+		if got := MatchPath(test.patterns, test.path); got != test.expected {
+			t.Errorf("MatchPath(%q, %q): got %v, expected %v", test.patterns, test.path, got, test.expected)
+		}
+	}
+}
+
+func TestPathEscaping(t *testing.T) {
+	root := &Task{Name: "a/b"}
+	vet := root.Subtask("%s", `run "go vet ./..."`)
+	percent := root.Subtask("%s", "100%/2F")
+	duplicate := root.Subtask("%s", `run "go vet ./..."`)
+
+	tests := []struct {
+		task     *Task
+		expected string
+	}{
+		{root, "a%2Fb"},
+		{vet, `a%2Fb/run "go vet .%2F..."`},
+		{percent, "a%2Fb/100%25%2F2F"},
+		{duplicate, `a%2Fb/run "go vet .%2F..."#2`},
+	}
+	for _, test := range tests {
+		if got := test.task.Path(); got != test.expected {
+			t.Errorf("%q: got %q, expected %q", test.task.Name, got, test.expected)
+		}
+	}
+}
+
+// testTree creates a pipeline with a task that has a slash in its name.
+func testTree() *Task {
+	root := &Task{Name: "Default"}
+	prepare := root.Subtask("Prepare")
+	prepare.Prepare = true
+	test := root.Subtask("Test")
+	test.Subtask("%s", `run "go vet ./..."`)
+	test.Subtask("%s", `run "go test ./..."`)
+	build := root.Subtask("Build")
+	build.Needs = []string{"Test"}
+	build.Subtask("compile")
+	return root
+}
+
+// taskPaths lists the paths of the tree in depth-first order.
+func taskPaths(task *Task) []string {
+	paths := []string{task.Path()}
+	for _, subtask := range task.Tasks {
+		paths = append(paths, taskPaths(subtask)...)
+	}
+	return paths
+}
+
+func TestFilter(t *testing.T) {
+	tests := []struct {
+		name        string
+		include     []string
+		exclude     []string
+		keepPrepare bool
+		expected    []string
+	}{
+		{
+			name: "everything",
+			expected: []string{
+				"Default", "Default/Prepare", "Default/Test",
+				`Default/Test/run "go vet .%2F..."`, `Default/Test/run "go test .%2F..."`,
+				"Default/Build", "Default/Build/compile",
+			},
+		},
+		{
+			name:    "only",
+			include: []string{"Test/run*"},
+			expected: []string{
+				"Default", "Default/Test",
+				`Default/Test/run "go vet .%2F..."`, `Default/Test/run "go test .%2F..."`,
+			},
+		},
+		{
+			name:    "skip",
+			exclude: []string{`run "go vet*`},
+			expected: []string{
+				"Default", "Default/Prepare", "Default/Test",
+				`Default/Test/run "go test .%2F..."`,
+				"Default/Build", "Default/Build/compile",
+			},
+		},
+		{
+			name:        "keep prepare",
+			include:     []string{"compile"},
+			keepPrepare: true,
+			expected:    []string{"Default", "Default/Prepare", "Default/Build", "Default/Build/compile"},
+		},
+		{
+			name:        "skip prepare",
+			include:     []string{"compile"},
+			exclude:     []string{"Prepare"},
+			keepPrepare: true,
+			expected:    []string{"Default", "Default/Build", "Default/Build/compile"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			root := testTree()
+			if err := root.Filter(test.include, test.exclude, test.keepPrepare); err != nil {
+				t.Fatal(err)
+			}
+			if got := taskPaths(root); !reflect.DeepEqual(got, test.expected) {
+				t.Errorf("got %q, expected %q", got, test.expected)
+			}
+		})
+	}
+}
+
+func TestFilterDropsRemovedNeeds(t *testing.T) {
+	root := testTree()
+	if err := root.Filter([]string{"Build"}, nil, false); err != nil {
+		t.Fatal(err)
+	}
+	build := root.Tasks[0]
+	if build.Name != "Build" || len(build.Needs) != 0 {
+		t.Errorf("got %v needing %v, expected Build without needs", build.Name, build.Needs)
+	}
+}
+
+func TestFilterErrors(t *testing.T) {
+	tests := []struct {
+		name             string
+		include, exclude []string
+	}{
+		{"invalid pattern", []string{"["}, nil},
+		{"invalid skip pattern", nil, []string{"["}},
+		{"no match", []string{"Tset"}, nil},
+		{"one of the patterns does not match", []string{"Test", "Biuld"}, nil},
+		{"everything skipped", []string{"Test"}, []string{"Test"}},
+		{"root skipped", nil, []string{"Default"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			root := testTree()
+			if err := root.Filter(test.include, test.exclude, false); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
@@ -2,4 +2,7 @@ module github.com/loov/ci
 
 go 1.12
 
-require golang.org/x/sync v0.0.0-20181221193216-37e7f081c4d4
+require (
+	golang.org/x/sync v0.0.0-20181221193216-37e7f081c4d4
+	gopkg.in/yaml.v3 v3.0.1
+)
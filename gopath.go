@@ -13,6 +13,7 @@ type ChangeDir struct {
 // Setup sets up the step
 func (step *ChangeDir) Setup(parent *Task) {
 	task := parent.Subtask("cd %q", step.Target)
+	task.Prepare = true
 	task.Exec = func(context, _ *Context) error {
 		dir, err := context.ExpandEnv(step.Target)
 		if err != nil {
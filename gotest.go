@@ -0,0 +1,228 @@
+package ci
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// GoTest runs go test and reports every package and test as a subtask
+type GoTest struct {
+	Packages []string
+	Flags    []string
+}
+
+// Setup sets up the step
+func (step *GoTest) Setup(parent *Task) {
+	task := parent.Subtask("go test %v", strings.Join(step.Packages, " "))
+	task.reported = true
+	task.Exec = func(context, subcontext *Context) error {
+		args := step.args()
+		if context.Global.DryRun {
+			dir := subcontext.WorkingDir
+			if dir == "" {
+				dir, _ = os.Getwd()
+			}
+			context.Logger.Printf("would run %q in %q", "go "+strings.Join(args, " "), dir)
+			return nil
+		}
+
+		// results of the previous attempt
+		task.setSubtasks(nil)
+
+		context.Logger.Printf("run %q", "go "+strings.Join(args, " "))
+		cmd := exec.CommandContext(subcontext, "go", args...)
+		cmd.Dir = subcontext.WorkingDir
+		cmd.Env = subcontext.Env
+
+		stdout, stderr, flush := task.CommandOutput(context)
+		defer flush()
+
+		results := newTestResults()
+		events := &testEventWriter{results: results, output: stdout}
+		cmd.Stdout, cmd.Stderr = events, stderr
+
+		err := runCommand(subcontext, cmd)
+		events.Flush()
+		results.report(context, task)
+		return err
+	}
+}
+
+// args returns the arguments for go.
+func (step *GoTest) args() []string {
+	args := []string{"test", "-json"}
+	args = append(args, step.Flags...)
+	args = append(args, step.Packages...)
+	return args
+}
+
+// testEvent is a line of go test -json output.
+type testEvent struct {
+	Time    time.Time
+	Action  string
+	Package string
+	Test    string
+	Elapsed float64
+	Output  string
+}
+
+// testEventWriter parses go test -json output and
+// writes the human readable output to output.
+type testEventWriter struct {
+	results *testResults
+	output  io.Writer
+	partial []byte
+}
+
+func (writer *testEventWriter) Write(data []byte) (int, error) {
+	writer.partial = append(writer.partial, data...)
+	for {
+		newline := bytes.IndexByte(writer.partial, '\n')
+		if newline < 0 {
+			break
+		}
+		writer.line(writer.partial[:newline+1])
+		writer.partial = writer.partial[newline+1:]
+	}
+	return len(data), nil
+}
+
+// Flush handles the last line without a newline.
+func (writer *testEventWriter) Flush() error {
+	if len(writer.partial) > 0 {
+		writer.line(writer.partial)
+		writer.partial = nil
+	}
+	return nil
+}
+
+func (writer *testEventWriter) line(line []byte) {
+	var event testEvent
+	if err := json.Unmarshal(line, &event); err != nil || event.Action == "" {
+		_, _ = writer.output.Write(line)
+		return
+	}
+	if event.Action == "output" {
+		_, _ = io.WriteString(writer.output, event.Output)
+	}
+	writer.results.add(event)
+}
+
+// testResults collects the packages and tests of go test -json output.
+//
+// The tasks are created under a detached root,
+// so that the task tree does not change while the tests are running.
+type testResults struct {
+	root    Task
+	results map[string]*testResult
+	order   []*testResult
+}
+
+// testResult is a package or a test.
+type testResult struct {
+	task    *Task
+	name    string
+	started time.Time
+	elapsed time.Duration
+	action  string
+}
+
+func newTestResults() *testResults {
+	return &testResults{results: map[string]*testResult{}}
+}
+
+// result finds or creates the result of a package or a test,
+// subtests are nested under their parent test.
+func (results *testResults) result(pkg, test string) *testResult {
+	key := pkg + " " + test
+	if result, ok := results.results[key]; ok {
+		return result
+	}
+
+	var result *testResult
+	if test == "" {
+		result = &testResult{task: results.root.Subtask("%s", pkg), name: pkg}
+	} else {
+		parent := results.result(pkg, "")
+		name := test
+		if slash := strings.LastIndexByte(test, '/'); slash >= 0 {
+			parent = results.result(pkg, test[:slash])
+			name = test[slash+1:]
+		}
+		result = &testResult{task: parent.task.Subtask("%s", name), name: test}
+	}
+
+	results.results[key] = result
+	results.order = append(results.order, result)
+	return result
+}
+
+// add records an event.
+func (results *testResults) add(event testEvent) {
+	if event.Package == "" {
+		return
+	}
+
+	result := results.result(event.Package, event.Test)
+	switch event.Action {
+	case "start", "run":
+		if result.started.IsZero() {
+			result.started = event.Time
+		}
+	case "output":
+		_, _ = io.WriteString(&result.task.stdout, event.Output)
+	case "pass", "fail", "skip":
+		result.action = event.Action
+		result.elapsed = time.Duration(event.Elapsed * float64(time.Second))
+		if result.started.IsZero() {
+			result.started = event.Time.Add(-result.elapsed)
+		}
+	}
+}
+
+// report attaches the results to task and notifies the observer.
+func (results *testResults) report(context *Context, task *Task) {
+	for _, subtask := range results.root.Tasks {
+		subtask.parent = task
+	}
+	task.setSubtasks(results.root.Tasks)
+
+	observer := context.Global.observer()
+	for _, result := range results.order {
+		if result.started.IsZero() {
+			result.started = time.Now()
+		}
+
+		var err error
+		result.task.updateStatus(func(status *TaskStatus) {
+			status.Started = result.started
+			status.Finished = result.started.Add(result.elapsed)
+			status.Done = true
+			switch result.action {
+			case "skip":
+				status.Skipped = true
+			case "fail":
+				err = fmt.Errorf("%s failed", result.name)
+			case "":
+				err = fmt.Errorf("%s did not finish", result.name)
+			}
+			status.Errored = err != nil
+			status.ExecError = err
+		})
+
+		observer.TaskStarted(result.task)
+		switch {
+		case result.action == "skip":
+			observer.TaskSkipped(result.task)
+		case err != nil:
+			observer.TaskErrored(result.task, err)
+		}
+		observer.TaskFinished(result.task)
+	}
+}
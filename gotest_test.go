@@ -0,0 +1,135 @@
+package ci
+
+import (
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testEvents = `{"Time":"2020-01-01T00:00:00Z","Action":"start","Package":"x/a"}
+{"Time":"2020-01-01T00:00:00Z","Action":"run","Package":"x/a","Test":"TestPass"}
+{"Time":"2020-01-01T00:00:00Z","Action":"output","Package":"x/a","Test":"TestPass","Output":"=== RUN   TestPass\n"}
+{"Time":"2020-01-01T00:00:01Z","Action":"pass","Package":"x/a","Test":"TestPass","Elapsed":1}
+{"Time":"2020-01-01T00:00:01Z","Action":"run","Package":"x/a","Test":"TestSub"}
+{"Time":"2020-01-01T00:00:01Z","Action":"run","Package":"x/a","Test":"TestSub/one"}
+{"Time":"2020-01-01T00:00:01Z","Action":"output","Package":"x/a","Test":"TestSub/one","Output":"    a_test.go:10: broke\n"}
+{"Time":"2020-01-01T00:00:01Z","Action":"fail","Package":"x/a","Test":"TestSub/one","Elapsed":0}
+{"Time":"2020-01-01T00:00:01Z","Action":"fail","Package":"x/a","Test":"TestSub","Elapsed":0}
+{"Time":"2020-01-01T00:00:01Z","Action":"run","Package":"x/a","Test":"TestSkip"}
+{"Time":"2020-01-01T00:00:01Z","Action":"skip","Package":"x/a","Test":"TestSkip","Elapsed":0}
+{"Time":"2020-01-01T00:00:01Z","Action":"fail","Package":"x/a","Elapsed":1.5}
+{"Time":"2020-01-01T00:00:01Z","Action":"skip","Package":"x/b","Elapsed":0}
+`
+
+func TestGoTestResults(t *testing.T) {
+	global, cleanup := newTestContext(t)
+	defer cleanup()
+
+	root := &Task{Name: "root"}
+	task := root.Subtask("go test ./...")
+	task.reported = true
+
+	// the tree is printed concurrently, while the results are reported
+	started := make(chan struct{})
+	done := make(chan struct{})
+	printed := make(chan struct{})
+	go func() {
+		defer close(printed)
+		root.PrintTo(ioutil.Discard, "")
+		close(started)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				root.PrintTo(ioutil.Discard, "")
+			}
+		}
+	}()
+
+	var output strings.Builder
+	results := newTestResults()
+	events := &testEventWriter{results: results, output: &output}
+	// split writes in the middle of lines
+	for _, chunk := range []string{testEvents[:100], testEvents[100:]} {
+		_, _ = events.Write([]byte(chunk))
+	}
+	_ = events.Flush()
+	<-started
+	results.report(&global.Context, task)
+
+	close(done)
+	<-printed
+
+	if !strings.Contains(output.String(), "a_test.go:10: broke") {
+		t.Errorf("missing test output in %q", output.String())
+	}
+
+	states := map[string]string{}
+	var walk func(task *Task)
+	walk = func(task *Task) {
+		status := task.Status()
+		states[task.Path()] = status.State()
+		for _, subtask := range task.Subtasks() {
+			walk(subtask)
+		}
+	}
+	walk(task)
+
+	prefix := task.Path() + "/"
+	expected := map[string]string{
+		"x%2Fa":             "error",
+		"x%2Fa/TestPass":    "done",
+		"x%2Fa/TestSub":     "error",
+		"x%2Fa/TestSub/one": "error",
+		"x%2Fa/TestSkip":    "skipped",
+		"x%2Fb":             "skipped",
+	}
+	for path, state := range expected {
+		path = prefix + path
+		if states[path] != state {
+			t.Errorf("%s: got %q, expected %q", path, states[path], state)
+		}
+	}
+}
+
+func TestReportedTaskHoldsLimit(t *testing.T) {
+	global, cleanup := newTestContext(t)
+	defer cleanup()
+	global.MaxParallel = 1
+
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+
+	root := &Task{Name: "root", Parallel: true}
+	for i := 0; i < 3; i++ {
+		task := root.Subtask("go test")
+		task.reported = true
+		// results of an earlier attempt
+		task.Subtask("x/a")
+		task.Exec = func(context, subcontext *Context) error {
+			mu.Lock()
+			running++
+			if running > maxRunning {
+				maxRunning = running
+			}
+			mu.Unlock()
+
+			time.Sleep(10 * time.Millisecond)
+
+			mu.Lock()
+			running--
+			mu.Unlock()
+			return nil
+		}
+	}
+
+	if err := root.Run(&global.Context); err != nil {
+		t.Fatal(err)
+	}
+	if maxRunning != 1 {
+		t.Errorf("got %d concurrent runs, expected 1", maxRunning)
+	}
+}
@@ -0,0 +1,222 @@
+package ci
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// journalName is the file name of the journal inside the temporary directory of a run.
+const journalName = "run.json"
+
+// RunState is the persisted state of a run.
+type RunState struct {
+	Pipeline string               `json:"pipeline"`
+	GEnv     Env                  `json:"genv"`
+	Tasks    map[string]TaskState `json:"tasks"`
+}
+
+// TaskState is the persisted result of a finished task.
+type TaskState struct {
+	State string `json:"state"`
+	Error string `json:"error,omitempty"`
+	// Env and WorkingDir are set when the task changed the context of its caller
+	Env        Env    `json:"env,omitempty"`
+	WorkingDir string `json:"workingDir,omitempty"`
+}
+
+// Succeeded returns whether the task can be skipped when resuming.
+func (state TaskState) Succeeded() bool {
+	switch state.State {
+	case "done", "skipped", "cached", "resumed":
+		return true
+	}
+	return false
+}
+
+// Journal persists the run state after each task,
+// so that a failed run can be resumed.
+type Journal struct {
+	path string
+
+	mu       sync.Mutex
+	state    RunState
+	previous map[string]TaskState
+}
+
+// newJournal creates an empty journal stored at path.
+func newJournal(path, pipeline string) *Journal {
+	return &Journal{
+		path: path,
+		state: RunState{
+			Pipeline: pipeline,
+			Tasks:    map[string]TaskState{},
+		},
+	}
+}
+
+// OpenJournal loads the journal of a previous run.
+func OpenJournal(runID string) (*Journal, error) {
+	if runID == "" || strings.ContainsAny(runID, `/\`) || runID == "." || runID == ".." {
+		return nil, fmt.Errorf("invalid run id %q", runID)
+	}
+
+	path := filepath.Join(os.TempDir(), runID, journalName)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("did not find run %q", runID)
+		}
+		return nil, err
+	}
+
+	journal := &Journal{path: path}
+	if err := json.Unmarshal(data, &journal.state); err != nil {
+		return nil, fmt.Errorf("invalid state of run %q: %v", runID, err)
+	}
+	if journal.state.Tasks == nil {
+		journal.state.Tasks = map[string]TaskState{}
+	}
+
+	journal.previous = map[string]TaskState{}
+	for path, state := range journal.state.Tasks {
+		journal.previous[path] = state
+	}
+	return journal, nil
+}
+
+// Dir returns the temporary directory of the run.
+func (journal *Journal) Dir() string { return filepath.Dir(journal.path) }
+
+// Pipeline returns the name of the recorded pipeline.
+func (journal *Journal) Pipeline() string { return journal.state.Pipeline }
+
+// State returns a copy of the recorded state.
+func (journal *Journal) State() RunState {
+	journal.mu.Lock()
+	defer journal.mu.Unlock()
+
+	state := journal.state
+	state.GEnv = state.GEnv.Clone()
+	state.Tasks = map[string]TaskState{}
+	for path, task := range journal.state.Tasks {
+		state.Tasks[path] = task
+	}
+	return state
+}
+
+// completed returns the state of the task at path,
+// when it succeeded in the resumed run.
+func (journal *Journal) completed(path string) (TaskState, bool) {
+	journal.mu.Lock()
+	defer journal.mu.Unlock()
+
+	state, ok := journal.previous[path]
+	return state, ok && state.Succeeded()
+}
+
+// record stores the state of a finished task and saves the journal.
+func (journal *Journal) record(path string, state TaskState, genv Env) error {
+	journal.mu.Lock()
+	defer journal.mu.Unlock()
+
+	journal.state.Tasks[path] = state
+	journal.state.GEnv = genv.Clone()
+
+	data, err := json.MarshalIndent(journal.state, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	// write to a temporary file first, so that an interrupted write
+	// does not corrupt the previous state
+	temp := journal.path + ".tmp"
+	if err := ioutil.WriteFile(temp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(temp, journal.path)
+}
+
+// resume skips the task when it succeeded in the resumed run and
+// restores the changes it made to the context.
+func (task *Task) resume(context *Context) bool {
+	journal := context.Global.Journal
+	if journal == nil || task.conditional() {
+		return false
+	}
+
+	state, ok := journal.completed(task.Path())
+	if !ok {
+		return false
+	}
+
+	if state.Env != nil {
+		context.Env = state.Env.Clone()
+	}
+	if state.WorkingDir != "" {
+		context.WorkingDir = state.WorkingDir
+	}
+
+	observer := context.Global.observer()
+	task.updateStatus(func(status *TaskStatus) {
+		status.Start()
+		status.Resumed = true
+		status.Finish()
+	})
+	observer.TaskStarted(task)
+	observer.TaskFinished(task)
+
+	context.Debugf("%v succeeded in the resumed run", task.Name)
+	return true
+}
+
+// record saves the result of the task to the journal,
+// before is the environment of context before the task started.
+func (task *Task) record(context *Context, before Env, workingDir string, err error) {
+	journal := context.Global.Journal
+	if journal == nil || context.Global.DryRun {
+		return
+	}
+
+	status := task.Status()
+	state := TaskState{State: status.State()}
+	if err != nil {
+		state.Error = err.Error()
+	}
+	if !equalEnv(before, context.Env) || workingDir != context.WorkingDir {
+		state.Env = context.Env.Clone()
+		state.WorkingDir = context.WorkingDir
+	}
+
+	if err := journal.record(task.Path(), state, context.Global.GEnv); err != nil {
+		context.Warnf("failed to save run state: %v", err)
+	}
+}
+
+// conditional returns whether the task or one of its ancestors
+// runs only depending on the failure of a sibling,
+// such tasks are never skipped when resuming.
+func (task *Task) conditional() bool {
+	for ; task != nil; task = task.parent {
+		if task.When != OnSuccess {
+			return true
+		}
+	}
+	return false
+}
+
+func equalEnv(a, b Env) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
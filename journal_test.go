@@ -0,0 +1,132 @@
+package ci
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestJournalResume(t *testing.T) {
+	global, cleanup := newTestContext(t)
+	defer cleanup()
+	global.EnableJournal("Default")
+
+	workDir := filepath.Join(global.TempDir(), "work")
+	counts := map[string]int{}
+	testFails := true
+
+	pipeline := func() *Task {
+		root := &Task{Name: "Default"}
+		setup := root.Subtask("setup")
+		setup.Exec = func(context, _ *Context) error {
+			counts["setup"]++
+			context.SetEnv("MODE", "release")
+			context.Global.GEnv.Set("VERSION", "1.0")
+			context.WorkingDir = workDir
+			return nil
+		}
+		testLeaf(root, "build", func() error {
+			counts["build"]++
+			return nil
+		})
+		test := root.Subtask("test")
+		test.Exec = func(_, subcontext *Context) error {
+			counts["test"]++
+			if mode, _ := subcontext.Env.Get("MODE"); mode != "release" {
+				t.Errorf("got MODE=%q, expected the environment of setup", mode)
+			}
+			if subcontext.WorkingDir != workDir {
+				t.Errorf("got working dir %q, expected %q", subcontext.WorkingDir, workDir)
+			}
+			if version, _ := subcontext.Global.GEnv.Get("VERSION"); version != "1.0" {
+				t.Errorf("got VERSION=%q, expected the global environment of setup", version)
+			}
+			if testFails {
+				return fail()
+			}
+			return nil
+		}
+		finally := testLeaf(root, "finally", func() error {
+			counts["finally"]++
+			return nil
+		})
+		finally.When = Always
+		return root
+	}
+
+	first := pipeline()
+	if err := first.Run(&global.Context); err == nil {
+		t.Fatal("expected the first run to fail")
+	}
+
+	journal, err := OpenJournal(global.RunID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if journal.Pipeline() != "Default" {
+		t.Errorf("got pipeline %q, expected Default", journal.Pipeline())
+	}
+	state := journal.State()
+	if task := state.Tasks["Default/test"]; task.State != "error" || task.Error != "fail" {
+		t.Errorf("got %+v, expected the failure of test", task)
+	}
+	if task := state.Tasks["Default/setup"]; task.WorkingDir != workDir || len(task.Env) == 0 {
+		t.Errorf("got %+v, expected the changed environment of setup", task)
+	}
+
+	resumed, err := ResumeGlobalContext(context.Background(), ".", journal, NewStdOutput(ioutil.Discard, ioutil.Discard))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resumed.Quiet = true
+	if version, _ := resumed.GEnv.Get("VERSION"); version != "1.0" {
+		t.Errorf("got VERSION=%q, expected the recorded global environment", version)
+	}
+
+	testFails = false
+	second := pipeline()
+	if err := second.Run(&resumed.Context); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := map[string]int{"setup": 1, "build": 1, "test": 2, "finally": 2}
+	for name, count := range expected {
+		if counts[name] != count {
+			t.Errorf("%s: ran %d times, expected %d", name, counts[name], count)
+		}
+	}
+	expectState(t, second.Tasks[0], "resumed")
+	expectState(t, second.Tasks[1], "resumed")
+	expectState(t, second.Tasks[2], "done")
+	expectState(t, second.Tasks[3], "done")
+}
+
+func TestOpenJournalInvalid(t *testing.T) {
+	for _, runID := range []string{"", ".", "..", "a/b", `a\b`, "ci-does-not-exist"} {
+		if _, err := OpenJournal(runID); err == nil {
+			t.Errorf("OpenJournal(%q): expected an error", runID)
+		}
+	}
+}
+
+func TestTaskStateSucceeded(t *testing.T) {
+	tests := []struct {
+		state    string
+		expected bool
+	}{
+		{"done", true},
+		{"skipped", true},
+		{"cached", true},
+		{"resumed", true},
+		{"error", false},
+		{"timeout", false},
+		{"allowed-failure", false},
+		{"pending", false},
+	}
+	for _, test := range tests {
+		if got := (TaskState{State: test.state}).Succeeded(); got != test.expected {
+			t.Errorf("%q: got %v, expected %v", test.state, got, test.expected)
+		}
+	}
+}
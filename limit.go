@@ -0,0 +1,46 @@
+package ci
+
+import (
+	"golang.org/x/sync/semaphore"
+)
+
+// parallelLimit returns the semaphore for MaxParallel, nil when unlimited.
+func (context *GlobalContext) parallelLimit() *semaphore.Weighted {
+	context.limit.once.Do(func() {
+		if context.MaxParallel > 0 {
+			context.limit.sem = semaphore.NewWeighted(int64(context.MaxParallel))
+		}
+	})
+	return context.limit.sem
+}
+
+// withLimit returns limits with an additional semaphore of size n.
+func withLimit(limits []*semaphore.Weighted, n int) []*semaphore.Weighted {
+	return append(limits[:len(limits):len(limits)], semaphore.NewWeighted(int64(n)))
+}
+
+// acquireLimits acquires a slot from the global limit and from every
+// enclosing limited parallel stage, outermost first to avoid deadlocks.
+func acquireLimits(context *Context) (release func(), err error) {
+	var acquired []*semaphore.Weighted
+	release = func() {
+		for i := len(acquired) - 1; i >= 0; i-- {
+			acquired[i].Release(1)
+		}
+	}
+
+	var limits []*semaphore.Weighted
+	if global := context.Global.parallelLimit(); global != nil {
+		limits = append(limits, global)
+	}
+	limits = append(limits, context.limits...)
+
+	for _, limit := range limits {
+		if err := limit.Acquire(context, 1); err != nil {
+			release()
+			return nil, err
+		}
+		acquired = append(acquired, limit)
+	}
+	return release, nil
+}
@@ -0,0 +1,118 @@
+package ci
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// concurrency counts the peak number of concurrently running leaves.
+type concurrency struct {
+	mu      sync.Mutex
+	running int
+	peak    int
+}
+
+func (counter *concurrency) leaf() error {
+	counter.mu.Lock()
+	counter.running++
+	if counter.running > counter.peak {
+		counter.peak = counter.running
+	}
+	counter.mu.Unlock()
+
+	time.Sleep(20 * time.Millisecond)
+
+	counter.mu.Lock()
+	counter.running--
+	counter.mu.Unlock()
+	return nil
+}
+
+func TestParallelLimits(t *testing.T) {
+	tests := []struct {
+		name        string
+		global      int
+		stage       int
+		leaves      int
+		expected    int
+		nestedStage int
+	}{
+		{name: "unlimited", leaves: 6, expected: 6},
+		{name: "global", global: 2, leaves: 6, expected: 2},
+		{name: "stage", stage: 3, leaves: 6, expected: 3},
+		{name: "global below stage", global: 2, stage: 4, leaves: 6, expected: 2},
+		{name: "stage below global", global: 4, stage: 2, leaves: 6, expected: 2},
+		{name: "nested stage", stage: 3, nestedStage: 1, leaves: 6, expected: 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			global, cleanup := newTestContext(t)
+			defer cleanup()
+			global.MaxParallel = test.global
+
+			var counter concurrency
+			root := &Task{Name: "root", Parallel: true, MaxParallel: test.stage}
+			parent := root
+			if test.nestedStage > 0 {
+				parent = root.Subtask("nested")
+				parent.Parallel = true
+				parent.MaxParallel = test.nestedStage
+			}
+			for i := 0; i < test.leaves; i++ {
+				testLeaf(parent, "leaf", counter.leaf)
+			}
+
+			if err := root.Run(&global.Context); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if counter.peak != test.expected {
+				t.Errorf("got %d concurrent leaves, expected %d", counter.peak, test.expected)
+			}
+		})
+	}
+}
+
+func TestParallelLimitsAcrossStages(t *testing.T) {
+	global, cleanup := newTestContext(t)
+	defer cleanup()
+	global.MaxParallel = 3
+
+	var total, first, second concurrency
+	both := func(stage *concurrency) func() error {
+		return func() error {
+			done := make(chan struct{})
+			go func() {
+				_ = stage.leaf()
+				close(done)
+			}()
+			err := total.leaf()
+			<-done
+			return err
+		}
+	}
+
+	root := &Task{Name: "root", Parallel: true}
+	for _, stage := range []struct {
+		name    string
+		counter *concurrency
+	}{{"first", &first}, {"second", &second}} {
+		task := root.Subtask(stage.name)
+		task.Parallel = true
+		task.MaxParallel = 2
+		for i := 0; i < 4; i++ {
+			testLeaf(task, "leaf", both(stage.counter))
+		}
+	}
+
+	if err := root.Run(&global.Context); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if total.peak != 3 {
+		t.Errorf("got %d concurrent leaves, expected the global limit 3", total.peak)
+	}
+	if first.peak > 2 || second.peak > 2 {
+		t.Errorf("got %d and %d concurrent leaves in stages, expected at most 2", first.peak, second.peak)
+	}
+}
@@ -1,39 +1,158 @@
 package ci
 
 import (
+	"fmt"
 	"io"
 	"log"
 	"os"
+	"strconv"
+	"strings"
 )
 
+// Level defines the severity of a log message
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+// String returns the name of the level.
+func (level Level) String() string {
+	switch level {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	default:
+		return "Level(" + strconv.Itoa(int(level)) + ")"
+	}
+}
+
+// StdLogger writes info and below to stdout and errors to stderr.
 type StdLogger struct {
-	log *log.Logger
+	// Level is the minimum level of messages printed
+	Level Level
+
+	path   string
+	fields string
+	stdout *Mux
+	stderr *Mux
+	out    *log.Logger
+	err    *log.Logger
 }
 
 func NewStd() Logger {
-	return &StdLogger{log.New(os.Stdout, "", log.Ltime)}
+	return NewStdOutput(os.Stdout, os.Stderr)
+}
+
+// NewStdOutput creates a logger that writes to the given outputs.
+func NewStdOutput(stdout, stderr io.Writer) Logger {
+	return newStdLogger(NewMux(stdout), NewMux(stderr), "")
 }
 
+func newStdLogger(stdout, stderr *Mux, path string) *StdLogger {
+	return &StdLogger{
+		Level:  LevelInfo,
+		path:   path,
+		stdout: stdout,
+		stderr: stderr,
+		out:    log.New(stdout.Writer(path), "", log.Ltime),
+		err:    log.New(stderr.Writer(path), "", log.Ltime),
+	}
+}
+
+// Named returns a logger which prefixes lines with the slash separated path.
 func (log *StdLogger) Named(name string) Logger {
-	return log
+	path := name
+	if log.path != "" {
+		path = log.path + "/" + name
+	}
+	named := newStdLogger(log.stdout, log.stderr, path)
+	named.Level = log.Level
+	named.fields = log.fields
+	return named
+}
+
+// With returns a logger that appends key=value to every message.
+func (log *StdLogger) With(key string, value interface{}) Logger {
+	with := *log
+	with.fields += " " + key + "=" + formatField(value)
+	return &with
 }
 
+// Output returns line prefixing writers, the caller must Flush them when done.
 func (log *StdLogger) Output() (stdout, stderr io.Writer) {
-	return os.Stdout, os.Stderr
+	return log.stdout.Writer(log.path), log.stderr.Writer(log.path)
+}
+
+func (log *StdLogger) output(level Level, message string) {
+	if level < log.Level {
+		return
+	}
+
+	message = strings.TrimSuffix(message, "\n") + log.fields
+	switch level {
+	case LevelInfo:
+		log.out.Print(message)
+	case LevelError:
+		log.err.Print(level.String() + " " + message)
+	default:
+		log.out.Print(level.String() + " " + message)
+	}
 }
 
 func (log *StdLogger) Print(v ...interface{}) {
-	log.log.Print(v...)
+	log.output(LevelInfo, fmt.Sprint(v...))
 }
 
 func (log *StdLogger) Printf(format string, v ...interface{}) {
-	log.log.Printf(format, v...)
+	log.output(LevelInfo, fmt.Sprintf(format, v...))
+}
+
+func (log *StdLogger) Debug(v ...interface{}) {
+	log.output(LevelDebug, fmt.Sprint(v...))
+}
+
+func (log *StdLogger) Debugf(format string, v ...interface{}) {
+	log.output(LevelDebug, fmt.Sprintf(format, v...))
+}
+
+func (log *StdLogger) Info(v ...interface{}) {
+	log.output(LevelInfo, fmt.Sprint(v...))
+}
+
+func (log *StdLogger) Infof(format string, v ...interface{}) {
+	log.output(LevelInfo, fmt.Sprintf(format, v...))
+}
+
+func (log *StdLogger) Warn(v ...interface{}) {
+	log.output(LevelWarn, fmt.Sprint(v...))
+}
+
+func (log *StdLogger) Warnf(format string, v ...interface{}) {
+	log.output(LevelWarn, fmt.Sprintf(format, v...))
 }
 
 func (log *StdLogger) Error(v ...interface{}) {
-	log.log.Print(v...)
+	log.output(LevelError, fmt.Sprint(v...))
 }
 
 func (log *StdLogger) Errorf(format string, v ...interface{}) {
-	log.log.Printf(format, v...)
+	log.output(LevelError, fmt.Sprintf(format, v...))
+}
+
+// formatField formats a field value, quoting it when necessary.
+func formatField(value interface{}) string {
+	s := fmt.Sprint(value)
+	if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+		return strconv.Quote(s)
+	}
+	return s
 }
@@ -0,0 +1,113 @@
+package ci
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var rxLogTime = regexp.MustCompile(`\d\d:\d\d:\d\d `)
+
+// logLines returns the logged lines without timestamps.
+func logLines(buf *bytes.Buffer) []string {
+	text := rxLogTime.ReplaceAllString(buf.String(), "")
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+func TestStdLoggerLevels(t *testing.T) {
+	tests := []struct {
+		level          Level
+		stdout, stderr []string
+	}{
+		{LevelDebug, []string{"DEBUG debug", "info", "WARN warn"}, []string{"ERROR error"}},
+		{LevelInfo, []string{"info", "WARN warn"}, []string{"ERROR error"}},
+		{LevelWarn, []string{"WARN warn"}, []string{"ERROR error"}},
+		{LevelError, nil, []string{"ERROR error"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.level.String(), func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			log := NewStdOutput(&stdout, &stderr).(*StdLogger)
+			log.Level = test.level
+
+			log.Debug("debug")
+			log.Infof("%s", "info")
+			log.Warn("warn")
+			log.Errorf("%s", "error")
+
+			if got := logLines(&stdout); strings.Join(got, "\n") != strings.Join(test.stdout, "\n") {
+				t.Errorf("stdout: got %q, expected %q", got, test.stdout)
+			}
+			if got := logLines(&stderr); strings.Join(got, "\n") != strings.Join(test.stderr, "\n") {
+				t.Errorf("stderr: got %q, expected %q", got, test.stderr)
+			}
+		})
+	}
+}
+
+func TestStdLoggerNamedAndFields(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	root := NewStdOutput(&stdout, &stderr)
+	root.(*StdLogger).Level = LevelDebug
+
+	build := root.Named("Default").With("attempt", 2).Named("build")
+	build.Print("compiling")
+	build.With("file", "main go").Debugf("checked %d", 3)
+	build.Error("failed")
+	root.Print("done")
+
+	expectedOut := []string{
+		"Default/build │ compiling attempt=2",
+		`Default/build │ DEBUG checked 3 attempt=2 file="main go"`,
+		"done",
+	}
+	if got := logLines(&stdout); strings.Join(got, "\n") != strings.Join(expectedOut, "\n") {
+		t.Errorf("stdout: got %q, expected %q", got, expectedOut)
+	}
+	expectedErr := []string{"Default/build │ ERROR failed attempt=2"}
+	if got := logLines(&stderr); strings.Join(got, "\n") != strings.Join(expectedErr, "\n") {
+		t.Errorf("stderr: got %q, expected %q", got, expectedErr)
+	}
+}
+
+func TestStdLoggerOutput(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	log := NewStdOutput(&stdout, &stderr).Named("test")
+
+	out, errs := log.Output()
+	_, _ = out.Write([]byte("ok\npartial"))
+	_, _ = errs.Write([]byte("warning\n"))
+	flushWriter(out)
+	flushWriter(errs)
+
+	if got := stdout.String(); got != "test │ ok\ntest │ partial\n" {
+		t.Errorf("stdout: got %q", got)
+	}
+	if got := stderr.String(); got != "test │ warning\n" {
+		t.Errorf("stderr: got %q", got)
+	}
+}
+
+func TestFormatField(t *testing.T) {
+	tests := []struct {
+		value    interface{}
+		expected string
+	}{
+		{"plain", "plain"},
+		{42, "42"},
+		{"", `""`},
+		{"with space", `"with space"`},
+		{"a=b", `"a=b"`},
+		{`say "hi"`, `"say \"hi\""`},
+	}
+	for _, test := range tests {
+		if got := formatField(test.value); got != test.expected {
+			t.Errorf("formatField(%v): got %q, expected %q", test.value, got, test.expected)
+		}
+	}
+}
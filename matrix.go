@@ -0,0 +1,133 @@
+package ci
+
+import (
+	"sort"
+	"strings"
+)
+
+// Matrix runs steps in parallel for every combination of the variable values
+type Matrix struct {
+	Vars map[string][]string
+	// Include adds values to the combinations that match its variable values,
+	// or a new combination when it matches none
+	Include []map[string]string
+	// Exclude removes combinations that match all of the given values
+	Exclude []map[string]string
+	Steps   []Step
+}
+
+// Setup sets up the step
+func (step *Matrix) Setup(parent *Task) {
+	task := parent.Subtask("matrix")
+	task.Parallel = true
+
+	for _, combination := range step.Combinations() {
+		combination := combination
+		subtask := task.Subtask("%s", formatCombination(combination))
+		subtask.Exec = func(_, subcontext *Context) error {
+			for key, value := range combination {
+				subcontext.SetEnv(key, value)
+			}
+			return nil
+		}
+		subtask.AddSteps(step.Steps)
+	}
+}
+
+// Combinations returns all combinations of variable values,
+// with exclusions removed and inclusions added.
+func (step *Matrix) Combinations() []map[string]string {
+	keys := make([]string, 0, len(step.Vars))
+	for key := range step.Vars {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	combinations := []map[string]string{{}}
+	for _, key := range keys {
+		var next []map[string]string
+		for _, combination := range combinations {
+			for _, value := range step.Vars[key] {
+				extended := make(map[string]string, len(combination)+1)
+				for k, v := range combination {
+					extended[k] = v
+				}
+				extended[key] = value
+				next = append(next, extended)
+			}
+		}
+		combinations = next
+	}
+
+	var result []map[string]string
+	for _, combination := range combinations {
+		if len(combination) > 0 && !matchesAny(combination, step.Exclude) {
+			result = append(result, combination)
+		}
+	}
+
+	// includes extend the combinations whose variable values they match,
+	// without overwriting them, the others are added as new combinations
+	product := len(result)
+	for _, include := range step.Include {
+		matched := false
+		for _, combination := range result[:product] {
+			if !step.extends(combination, include) {
+				continue
+			}
+			for key, value := range include {
+				combination[key] = value
+			}
+			matched = true
+		}
+		if !matched {
+			added := make(map[string]string, len(include))
+			for key, value := range include {
+				added[key] = value
+			}
+			result = append(result, added)
+		}
+	}
+
+	return result
+}
+
+// extends checks whether include has the same values as combination for all the matrix variables.
+func (step *Matrix) extends(combination, include map[string]string) bool {
+	for key, value := range include {
+		if _, variable := step.Vars[key]; variable && combination[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesAll checks whether combination contains all the values in filter.
+func matchesAll(combination, filter map[string]string) bool {
+	for key, value := range filter {
+		if combination[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesAny checks whether any of the filters matches the combination.
+func matchesAny(combination map[string]string, filters []map[string]string) bool {
+	for _, filter := range filters {
+		if matchesAll(combination, filter) {
+			return true
+		}
+	}
+	return false
+}
+
+// formatCombination formats combination as KEY=value pairs sorted by key.
+func formatCombination(combination map[string]string) string {
+	pairs := make([]string, 0, len(combination))
+	for key, value := range combination {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, " ")
+}
@@ -0,0 +1,180 @@
+package ci
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMatrixCombinations(t *testing.T) {
+	vars := map[string][]string{
+		"GOOS":   {"linux", "windows"},
+		"GOARCH": {"amd64", "386"},
+	}
+
+	tests := []struct {
+		name     string
+		matrix   Matrix
+		expected []string
+	}{
+		{
+			name:     "empty",
+			matrix:   Matrix{},
+			expected: nil,
+		},
+		{
+			name:   "all",
+			matrix: Matrix{Vars: vars},
+			expected: []string{
+				"GOARCH=amd64 GOOS=linux",
+				"GOARCH=amd64 GOOS=windows",
+				"GOARCH=386 GOOS=linux",
+				"GOARCH=386 GOOS=windows",
+			},
+		},
+		{
+			name: "exclude",
+			matrix: Matrix{
+				Vars:    vars,
+				Exclude: []map[string]string{{"GOOS": "windows", "GOARCH": "386"}},
+			},
+			expected: []string{
+				"GOARCH=amd64 GOOS=linux",
+				"GOARCH=amd64 GOOS=windows",
+				"GOARCH=386 GOOS=linux",
+			},
+		},
+		{
+			name: "exclude partial",
+			matrix: Matrix{
+				Vars:    vars,
+				Exclude: []map[string]string{{"GOARCH": "386"}},
+			},
+			expected: []string{
+				"GOARCH=amd64 GOOS=linux",
+				"GOARCH=amd64 GOOS=windows",
+			},
+		},
+		{
+			name: "include",
+			matrix: Matrix{
+				Vars:    vars,
+				Exclude: []map[string]string{{"GOARCH": "386"}},
+				Include: []map[string]string{{"GOOS": "darwin", "GOARCH": "arm64"}},
+			},
+			expected: []string{
+				"GOARCH=amd64 GOOS=linux",
+				"GOARCH=amd64 GOOS=windows",
+				"GOARCH=arm64 GOOS=darwin",
+			},
+		},
+		{
+			name: "include existing",
+			matrix: Matrix{
+				Vars:    vars,
+				Include: []map[string]string{{"GOOS": "linux", "GOARCH": "amd64"}},
+			},
+			expected: []string{
+				"GOARCH=amd64 GOOS=linux",
+				"GOARCH=amd64 GOOS=windows",
+				"GOARCH=386 GOOS=linux",
+				"GOARCH=386 GOOS=windows",
+			},
+		},
+		{
+			name: "include excluded",
+			matrix: Matrix{
+				Vars:    vars,
+				Exclude: []map[string]string{{"GOOS": "windows"}},
+				Include: []map[string]string{{"GOOS": "windows", "GOARCH": "amd64"}},
+			},
+			expected: []string{
+				"GOARCH=amd64 GOOS=linux",
+				"GOARCH=386 GOOS=linux",
+				"GOARCH=amd64 GOOS=windows",
+			},
+		},
+		{
+			name: "include subset",
+			matrix: Matrix{
+				Vars:    vars,
+				Include: []map[string]string{{"GOOS": "linux"}},
+			},
+			expected: []string{
+				"GOARCH=amd64 GOOS=linux",
+				"GOARCH=amd64 GOOS=windows",
+				"GOARCH=386 GOOS=linux",
+				"GOARCH=386 GOOS=windows",
+			},
+		},
+		{
+			name: "include extends",
+			matrix: Matrix{
+				Vars:    vars,
+				Include: []map[string]string{{"GOOS": "linux", "CGO_ENABLED": "1"}},
+			},
+			expected: []string{
+				"CGO_ENABLED=1 GOARCH=amd64 GOOS=linux",
+				"GOARCH=amd64 GOOS=windows",
+				"CGO_ENABLED=1 GOARCH=386 GOOS=linux",
+				"GOARCH=386 GOOS=windows",
+			},
+		},
+		{
+			name: "include extends all",
+			matrix: Matrix{
+				Vars:    map[string][]string{"GOOS": {"linux", "windows"}},
+				Include: []map[string]string{{"CGO_ENABLED": "0"}},
+			},
+			expected: []string{
+				"CGO_ENABLED=0 GOOS=linux",
+				"CGO_ENABLED=0 GOOS=windows",
+			},
+		},
+		{
+			name: "include overwrites added values",
+			matrix: Matrix{
+				Vars: map[string][]string{"GOOS": {"linux", "windows"}},
+				Include: []map[string]string{
+					{"CGO_ENABLED": "0"},
+					{"GOOS": "linux", "CGO_ENABLED": "1"},
+				},
+			},
+			expected: []string{
+				"CGO_ENABLED=1 GOOS=linux",
+				"CGO_ENABLED=0 GOOS=windows",
+			},
+		},
+		{
+			name: "include does not extend excluded",
+			matrix: Matrix{
+				Vars:    vars,
+				Exclude: []map[string]string{{"GOOS": "windows"}},
+				Include: []map[string]string{{"GOOS": "windows", "CGO_ENABLED": "0"}},
+			},
+			expected: []string{
+				"GOARCH=amd64 GOOS=linux",
+				"GOARCH=386 GOOS=linux",
+				"CGO_ENABLED=0 GOOS=windows",
+			},
+		},
+		{
+			name: "only include",
+			matrix: Matrix{
+				Include: []map[string]string{{"GO": "1.12"}, {"GO": "1.13"}},
+			},
+			expected: []string{"GO=1.12", "GO=1.13"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got []string
+			for _, combination := range test.matrix.Combinations() {
+				got = append(got, formatCombination(combination))
+			}
+			if !reflect.DeepEqual(got, test.expected) {
+				t.Errorf("got %q, expected %q", got, test.expected)
+			}
+		})
+	}
+}
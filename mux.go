@@ -0,0 +1,128 @@
+package ci
+
+import (
+	"bytes"
+	"hash/fnv"
+	"io"
+	"os"
+	"sync"
+)
+
+// Mux writes whole lines from multiple writers to a single output,
+// so that the output of parallel tasks does not interleave mid-line.
+type Mux struct {
+	// Color enables coloring line prefixes
+	Color bool
+
+	mu  sync.Mutex
+	out io.Writer
+}
+
+// NewMux creates a multiplexer for out,
+// prefixes are colored when out is a terminal.
+func NewMux(out io.Writer) *Mux {
+	return &Mux{
+		Color: IsTerminal(out),
+		out:   out,
+	}
+}
+
+// Writer returns a writer that prefixes every line with prefix.
+func (mux *Mux) Writer(prefix string) *LineWriter {
+	writer := &LineWriter{mux: mux}
+	if prefix != "" {
+		if mux.Color {
+			writer.prefix = []byte(colorize(prefix, prefix+" │ "))
+		} else {
+			writer.prefix = []byte(prefix + " │ ")
+		}
+	}
+	return writer
+}
+
+func (mux *Mux) writeLines(prefix, lines []byte) error {
+	mux.mu.Lock()
+	defer mux.mu.Unlock()
+
+	var buf bytes.Buffer
+	for len(lines) > 0 {
+		line := lines
+		if p := bytes.IndexByte(lines, '\n'); p >= 0 {
+			line = lines[:p+1]
+		}
+		lines = lines[len(line):]
+
+		buf.Write(prefix)
+		buf.Write(line)
+	}
+
+	_, err := mux.out.Write(buf.Bytes())
+	return err
+}
+
+// LineWriter buffers partial lines until they are complete.
+type LineWriter struct {
+	mux    *Mux
+	prefix []byte
+
+	mu      sync.Mutex
+	partial []byte
+}
+
+// Write writes all complete lines in p to the multiplexer.
+func (writer *LineWriter) Write(p []byte) (int, error) {
+	writer.mu.Lock()
+	defer writer.mu.Unlock()
+
+	writer.partial = append(writer.partial, p...)
+	end := bytes.LastIndexByte(writer.partial, '\n')
+	if end < 0 {
+		return len(p), nil
+	}
+
+	err := writer.mux.writeLines(writer.prefix, writer.partial[:end+1])
+	writer.partial = append(writer.partial[:0], writer.partial[end+1:]...)
+	return len(p), err
+}
+
+// Flush writes the remaining partial line.
+func (writer *LineWriter) Flush() error {
+	writer.mu.Lock()
+	defer writer.mu.Unlock()
+
+	if len(writer.partial) == 0 {
+		return nil
+	}
+
+	writer.partial = append(writer.partial, '\n')
+	err := writer.mux.writeLines(writer.prefix, writer.partial)
+	writer.partial = writer.partial[:0]
+	return err
+}
+
+var prefixColors = []string{
+	"\x1b[31m", "\x1b[32m", "\x1b[33m", "\x1b[34m", "\x1b[35m", "\x1b[36m",
+	"\x1b[91m", "\x1b[92m", "\x1b[93m", "\x1b[94m", "\x1b[95m", "\x1b[96m",
+}
+
+// colorize colors text with a color chosen by key,
+// the same key always gets the same color.
+func colorize(key, text string) string {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(key))
+	color := prefixColors[hash.Sum32()%uint32(len(prefixColors))]
+	return color + text + "\x1b[0m"
+}
+
+// IsTerminal checks whether w is a terminal.
+func IsTerminal(w io.Writer) bool {
+	file, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	return stat.Mode()&os.ModeCharDevice != 0
+}
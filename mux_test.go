@@ -0,0 +1,119 @@
+package ci
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestLineWriter(t *testing.T) {
+	tests := []struct {
+		name     string
+		prefix   string
+		writes   []string
+		written  string
+		expected string
+	}{
+		{
+			name:     "whole lines",
+			prefix:   "build",
+			writes:   []string{"one\ntwo\n"},
+			written:  "build │ one\nbuild │ two\n",
+			expected: "build │ one\nbuild │ two\n",
+		},
+		{
+			name:     "partial writes",
+			prefix:   "build",
+			writes:   []string{"o", "ne\ntw", "o", "\n"},
+			written:  "build │ one\nbuild │ two\n",
+			expected: "build │ one\nbuild │ two\n",
+		},
+		{
+			name:     "trailing partial line",
+			prefix:   "test",
+			writes:   []string{"one\npart", "ial"},
+			written:  "test │ one\n",
+			expected: "test │ one\ntest │ partial\n",
+		},
+		{
+			name:     "empty lines",
+			prefix:   "test",
+			writes:   []string{"\n\n"},
+			written:  "test │ \ntest │ \n",
+			expected: "test │ \ntest │ \n",
+		},
+		{
+			name:     "no prefix",
+			writes:   []string{"one\ntwo"},
+			written:  "one\n",
+			expected: "one\ntwo\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var out bytes.Buffer
+			mux := NewMux(&out)
+			writer := mux.Writer(test.prefix)
+			for _, write := range test.writes {
+				n, err := writer.Write([]byte(write))
+				if n != len(write) || err != nil {
+					t.Fatalf("got %d, %v, expected %d", n, err, len(write))
+				}
+			}
+			if out.String() != test.written {
+				t.Errorf("before flush got %q, expected %q", out.String(), test.written)
+			}
+			if err := writer.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != test.expected {
+				t.Errorf("after flush got %q, expected %q", out.String(), test.expected)
+			}
+		})
+	}
+}
+
+func TestMuxDoesNotInterleaveLines(t *testing.T) {
+	var out bytes.Buffer
+	mux := NewMux(&out)
+
+	const writers, lines = 8, 100
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		writer := mux.Writer(fmt.Sprint("w", i))
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for k := 0; k < lines; k++ {
+				// write each line in two parts
+				fmt.Fprintf(writer, "line %d", k)
+				fmt.Fprintf(writer, " of w%d\n", i)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	got := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(got) != writers*lines {
+		t.Fatalf("got %d lines, expected %d", len(got), writers*lines)
+	}
+	for _, line := range got {
+		var prefix string
+		var k, i int
+		if _, err := fmt.Sscanf(line, "%s │ line %d of w%d", &prefix, &k, &i); err != nil || prefix != fmt.Sprint("w", i) {
+			t.Errorf("interleaved line %q", line)
+		}
+	}
+}
+
+func TestColorize(t *testing.T) {
+	if colorize("build", "x") != colorize("build", "x") {
+		t.Error("expected the same color for the same key")
+	}
+	if !strings.HasSuffix(colorize("build", "x"), "x\x1b[0m") {
+		t.Error("expected the color to be reset")
+	}
+}
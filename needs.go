@@ -0,0 +1,104 @@
+package ci
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Validate checks the task tree for unknown and cyclic Needs.
+func (task *Task) Validate() error {
+	if err := task.checkNeeds(); err != nil {
+		return err
+	}
+	for _, subtask := range task.Tasks {
+		if err := subtask.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hasNeeds checks whether any of the subtasks depends on a sibling.
+func (task *Task) hasNeeds() bool {
+	for _, subtask := range task.Tasks {
+		if len(subtask.Needs) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// subtask finds a subtask that runs on success by name.
+func (task *Task) subtask(name string) *Task {
+	for _, subtask := range task.Tasks {
+		if subtask.When == OnSuccess && subtask.Name == name {
+			return subtask
+		}
+	}
+	return nil
+}
+
+// checkNeeds verifies that subtask Needs refer to siblings and do not form a cycle.
+func (task *Task) checkNeeds() error {
+	if !task.hasNeeds() {
+		return nil
+	}
+
+	for _, subtask := range task.Tasks {
+		for _, need := range subtask.Needs {
+			if subtask.When != OnSuccess {
+				return fmt.Errorf("%v: finally and on-failure tasks cannot have needs", subtask.Path())
+			}
+			if task.subtask(need) == nil {
+				return fmt.Errorf("%v: needs unknown task %q, known: %v", subtask.Path(), need, task.subtaskNames())
+			}
+		}
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := map[*Task]int{}
+
+	var visit func(subtask *Task, chain []string) error
+	visit = func(subtask *Task, chain []string) error {
+		chain = append(chain, subtask.Name)
+		switch state[subtask] {
+		case visiting:
+			return fmt.Errorf("%v: dependency cycle %v", task.Path(), strings.Join(chain, " -> "))
+		case visited:
+			return nil
+		}
+
+		state[subtask] = visiting
+		for _, need := range subtask.Needs {
+			if err := visit(task.subtask(need), chain); err != nil {
+				return err
+			}
+		}
+		state[subtask] = visited
+		return nil
+	}
+
+	for _, subtask := range task.Tasks {
+		if err := visit(subtask, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// subtaskNames returns the sorted names of subtasks that can be needed.
+func (task *Task) subtaskNames() []string {
+	var names []string
+	for _, subtask := range task.Tasks {
+		if subtask.When == OnSuccess {
+			names = append(names, subtask.Name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,50 @@
+package ci
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckNeeds(t *testing.T) {
+	type sub struct {
+		name  string
+		needs []string
+		when  Condition
+	}
+
+	tests := []struct {
+		name  string
+		tasks []sub
+		err   string
+	}{
+		{name: "no needs", tasks: []sub{{name: "a"}, {name: "b"}}},
+		{name: "chain", tasks: []sub{{name: "a"}, {name: "b", needs: []string{"a"}}, {name: "c", needs: []string{"a", "b"}}}},
+		{name: "duplicate names", tasks: []sub{{name: "a"}, {name: "a"}, {name: "b", needs: []string{"a"}}}},
+		{name: "unknown", tasks: []sub{{name: "a", needs: []string{"x"}}}, err: `needs unknown task "x", known: [a]`},
+		{name: "needs finally", tasks: []sub{{name: "a"}, {name: "f", when: Always}, {name: "b", needs: []string{"f"}}}, err: `needs unknown task "f"`},
+		{name: "finally with needs", tasks: []sub{{name: "a"}, {name: "f", when: Always, needs: []string{"a"}}}, err: "finally and on-failure tasks cannot have needs"},
+		{name: "self", tasks: []sub{{name: "a", needs: []string{"a"}}}, err: "dependency cycle a -> a"},
+		{name: "cycle", tasks: []sub{{name: "a", needs: []string{"b"}}, {name: "b", needs: []string{"a"}}}, err: "dependency cycle a -> b -> a"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			root := &Task{Name: "root"}
+			for _, sub := range test.tasks {
+				subtask := root.Subtask("%s", sub.name)
+				subtask.Needs = sub.needs
+				subtask.When = sub.when
+			}
+
+			err := root.Validate()
+			switch {
+			case test.err == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case test.err != "" && err == nil:
+				t.Errorf("expected error %q", test.err)
+			case test.err != "" && !strings.Contains(err.Error(), test.err):
+				t.Errorf("got %q, expected %q", err, test.err)
+			}
+		})
+	}
+}
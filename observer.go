@@ -0,0 +1,174 @@
+package ci
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// Observer receives task lifecycle events.
+//
+// Methods may be called concurrently from parallel tasks.
+type Observer interface {
+	// TaskStarted is called before the task starts running
+	TaskStarted(task *Task)
+	// TaskSkipped is called when the task decides to skip itself
+	TaskSkipped(task *Task)
+	// TaskAttempt is called after every run of the task
+	TaskAttempt(task *Task, attempt Attempt)
+	// TaskErrored is called when the task fails
+	TaskErrored(task *Task, err error)
+	// TaskFinished is called after the task has completed, regardless of the outcome
+	TaskFinished(task *Task)
+	// TaskOutput is called with a chunk of command output,
+	// stream is either "stdout" or "stderr", data must not be retained
+	TaskOutput(task *Task, stream string, data []byte)
+}
+
+// NopObserver ignores all events, it can be embedded to implement only some of the methods.
+type NopObserver struct{}
+
+func (NopObserver) TaskStarted(task *Task)                            {}
+func (NopObserver) TaskSkipped(task *Task)                            {}
+func (NopObserver) TaskAttempt(task *Task, attempt Attempt)           {}
+func (NopObserver) TaskErrored(task *Task, err error)                 {}
+func (NopObserver) TaskFinished(task *Task)                           {}
+func (NopObserver) TaskOutput(task *Task, stream string, data []byte) {}
+
+// Observers forwards events to all observers.
+type Observers []Observer
+
+func (observers Observers) TaskStarted(task *Task) {
+	for _, observer := range observers {
+		observer.TaskStarted(task)
+	}
+}
+
+func (observers Observers) TaskSkipped(task *Task) {
+	for _, observer := range observers {
+		observer.TaskSkipped(task)
+	}
+}
+
+func (observers Observers) TaskAttempt(task *Task, attempt Attempt) {
+	for _, observer := range observers {
+		observer.TaskAttempt(task, attempt)
+	}
+}
+
+func (observers Observers) TaskErrored(task *Task, err error) {
+	for _, observer := range observers {
+		observer.TaskErrored(task, err)
+	}
+}
+
+func (observers Observers) TaskFinished(task *Task) {
+	for _, observer := range observers {
+		observer.TaskFinished(task)
+	}
+}
+
+func (observers Observers) TaskOutput(task *Task, stream string, data []byte) {
+	for _, observer := range observers {
+		observer.TaskOutput(task, stream, data)
+	}
+}
+
+// outputObserver forwards written data to an observer.
+type outputObserver struct {
+	task     *Task
+	stream   string
+	observer Observer
+}
+
+func (output *outputObserver) Write(data []byte) (int, error) {
+	output.observer.TaskOutput(output.task, output.stream, data)
+	return len(data), nil
+}
+
+// Event is a single task lifecycle event written by JSONObserver.
+type Event struct {
+	Time     time.Time  `json:"time"`
+	Type     string     `json:"type"`
+	Task     string     `json:"task"`
+	Status   string     `json:"status,omitempty"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+	Attempt  int        `json:"attempt,omitempty"`
+	Error    string     `json:"error,omitempty"`
+	Stream   string     `json:"stream,omitempty"`
+	Output   string     `json:"output,omitempty"`
+}
+
+// JSONObserver writes events as JSON Lines.
+type JSONObserver struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+}
+
+// NewJSONObserver creates an observer that writes one event per line to w.
+func NewJSONObserver(w io.Writer) *JSONObserver {
+	return &JSONObserver{encoder: json.NewEncoder(w)}
+}
+
+func (observer *JSONObserver) write(event Event) {
+	observer.mu.Lock()
+	defer observer.mu.Unlock()
+	_ = observer.encoder.Encode(event)
+}
+
+func (observer *JSONObserver) event(typ string, task *Task) Event {
+	status := task.Status()
+	event := Event{
+		Time:   time.Now(),
+		Type:   typ,
+		Task:   task.Path(),
+		Status: status.State(),
+	}
+	if !status.Started.IsZero() {
+		event.Started = &status.Started
+	}
+	if !status.Finished.IsZero() {
+		event.Finished = &status.Finished
+	}
+	return event
+}
+
+func (observer *JSONObserver) TaskStarted(task *Task) {
+	observer.write(observer.event("start", task))
+}
+
+func (observer *JSONObserver) TaskSkipped(task *Task) {
+	observer.write(observer.event("skip", task))
+}
+
+func (observer *JSONObserver) TaskAttempt(task *Task, attempt Attempt) {
+	event := observer.event("attempt", task)
+	event.Attempt = len(task.Status().Attempts)
+	event.Started, event.Finished = &attempt.Started, &attempt.Finished
+	if attempt.Err != nil {
+		event.Error = attempt.Err.Error()
+	}
+	observer.write(event)
+}
+
+func (observer *JSONObserver) TaskErrored(task *Task, err error) {
+	event := observer.event("error", task)
+	event.Error = err.Error()
+	observer.write(event)
+}
+
+func (observer *JSONObserver) TaskFinished(task *Task) {
+	observer.write(observer.event("finish", task))
+}
+
+func (observer *JSONObserver) TaskOutput(task *Task, stream string, data []byte) {
+	observer.write(Event{
+		Time:   time.Now(),
+		Type:   "output",
+		Task:   task.Path(),
+		Stream: stream,
+		Output: string(data),
+	})
+}
@@ -0,0 +1,117 @@
+package ci
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestJSONObserver(t *testing.T) {
+	global, cleanup := newTestContext(t)
+	defer cleanup()
+
+	var out bytes.Buffer
+	global.Observer = NewJSONObserver(&out)
+
+	root := &Task{Name: "Default"}
+	build := root.Subtask("build")
+	build.Exec = func(context, _ *Context) error {
+		stdout, _, flush := build.CommandOutput(context)
+		defer flush()
+		fmt.Fprint(stdout, "compiled\n")
+		return nil
+	}
+	attempts := 0
+	test := testLeaf(root, "test", func() error {
+		attempts++
+		if attempts == 1 {
+			return fail()
+		}
+		return nil
+	})
+	test.Retry.Count = 1
+	testLeaf(root, "lint", func() error { return ErrSkip })
+
+	if err := root.Run(&global.Context); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	type expectedEvent struct {
+		Type, Task, Status string
+		Attempt            int
+		Error              string
+		Stream, Output     string
+	}
+	expected := []expectedEvent{
+		{Type: "start", Task: "Default", Status: "running"},
+		{Type: "start", Task: "Default/build", Status: "running"},
+		{Type: "output", Task: "Default/build", Stream: "stdout", Output: "compiled\n"},
+		{Type: "attempt", Task: "Default/build", Status: "running", Attempt: 1},
+		{Type: "finish", Task: "Default/build", Status: "done"},
+		{Type: "start", Task: "Default/test", Status: "running"},
+		{Type: "attempt", Task: "Default/test", Status: "running", Attempt: 1, Error: "fail"},
+		{Type: "attempt", Task: "Default/test", Status: "running", Attempt: 2},
+		{Type: "finish", Task: "Default/test", Status: "done"},
+		{Type: "start", Task: "Default/lint", Status: "running"},
+		{Type: "skip", Task: "Default/lint", Status: "skipped"},
+		{Type: "attempt", Task: "Default/lint", Status: "skipped", Attempt: 1},
+		{Type: "finish", Task: "Default/lint", Status: "skipped"},
+		{Type: "attempt", Task: "Default", Status: "running", Attempt: 1},
+		{Type: "finish", Task: "Default", Status: "done"},
+	}
+
+	var events []Event
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		var event Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("invalid event %q: %v", scanner.Text(), err)
+		}
+		if event.Time.IsZero() {
+			t.Errorf("event %q without time", scanner.Text())
+		}
+		if event.Type != "output" && event.Started == nil {
+			t.Errorf("event %q without started", scanner.Text())
+		}
+		if event.Type == "finish" && event.Finished == nil {
+			t.Errorf("event %q without finished", scanner.Text())
+		}
+		events = append(events, event)
+	}
+
+	for i := 0; i < len(events) || i < len(expected); i++ {
+		var got, want expectedEvent
+		if i < len(events) {
+			event := events[i]
+			got = expectedEvent{event.Type, event.Task, event.Status, event.Attempt, event.Error, event.Stream, event.Output}
+		}
+		if i < len(expected) {
+			want = expected[i]
+		}
+		if got != want {
+			t.Errorf("event %d: got %+v, expected %+v", i, got, want)
+		}
+	}
+}
+
+func TestEventFieldNames(t *testing.T) {
+	var out bytes.Buffer
+	observer := NewJSONObserver(&out)
+	task := &Task{Name: "Default"}
+	observer.TaskOutput(task, "stderr", []byte("warning\n"))
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &fields); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"time", "type", "task", "stream", "output"} {
+		if _, ok := fields[name]; !ok {
+			t.Errorf("missing field %q in %s", name, out.String())
+		}
+	}
+	if len(fields) != 5 {
+		t.Errorf("got fields %v, expected empty fields to be omitted", fields)
+	}
+}
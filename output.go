@@ -0,0 +1,80 @@
+package ci
+
+import "sync"
+
+// DefaultOutputLimit is the number of bytes kept by an OutputBuffer without a Limit.
+const DefaultOutputLimit = 1 << 20
+
+// OutputBuffer is a thread-safe buffer that keeps the last Limit bytes written.
+type OutputBuffer struct {
+	// Limit is the maximum number of bytes kept, 0 means DefaultOutputLimit
+	Limit int
+
+	mu      sync.Mutex
+	data    []byte
+	written int64
+}
+
+func (buffer *OutputBuffer) limit() int {
+	if buffer.Limit <= 0 {
+		return DefaultOutputLimit
+	}
+	return buffer.Limit
+}
+
+// Write appends p to the buffer, discarding the oldest data when over the limit.
+func (buffer *OutputBuffer) Write(p []byte) (int, error) {
+	buffer.mu.Lock()
+	defer buffer.mu.Unlock()
+
+	limit := buffer.limit()
+	buffer.written += int64(len(p))
+	if len(p) >= limit {
+		buffer.data = append(buffer.data[:0], p[len(p)-limit:]...)
+		return len(p), nil
+	}
+
+	// allow the buffer to grow up to twice the limit to avoid moving data on every write
+	if len(buffer.data)+len(p) > 2*limit {
+		keep := buffer.data[len(buffer.data)-(limit-len(p)):]
+		buffer.data = append(buffer.data[:0], keep...)
+	}
+	buffer.data = append(buffer.data, p...)
+	return len(p), nil
+}
+
+// tail returns the kept part of the data, must be called with the lock held.
+func (buffer *OutputBuffer) tail() []byte {
+	if limit := buffer.limit(); len(buffer.data) > limit {
+		return buffer.data[len(buffer.data)-limit:]
+	}
+	return buffer.data
+}
+
+// Bytes returns a copy of the kept data.
+func (buffer *OutputBuffer) Bytes() []byte {
+	buffer.mu.Lock()
+	defer buffer.mu.Unlock()
+	return append([]byte(nil), buffer.tail()...)
+}
+
+// String returns the kept data as a string.
+func (buffer *OutputBuffer) String() string {
+	buffer.mu.Lock()
+	defer buffer.mu.Unlock()
+	return string(buffer.tail())
+}
+
+// Len returns the number of bytes kept.
+func (buffer *OutputBuffer) Len() int {
+	buffer.mu.Lock()
+	defer buffer.mu.Unlock()
+	return len(buffer.tail())
+}
+
+// Discarded returns the number of bytes dropped because of the limit.
+func (buffer *OutputBuffer) Discarded() int64 {
+	buffer.mu.Lock()
+	defer buffer.mu.Unlock()
+	return buffer.written - int64(len(buffer.tail()))
+}
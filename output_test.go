@@ -0,0 +1,102 @@
+package ci
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestOutputBufferLimit(t *testing.T) {
+	tests := []struct {
+		name      string
+		limit     int
+		writes    []string
+		expected  string
+		discarded int64
+	}{
+		{"under limit", 8, []string{"abc", "def"}, "abcdef", 0},
+		{"at limit", 8, []string{"abcd", "efgh"}, "abcdefgh", 0},
+		{"over limit", 8, []string{"abcd", "efgh", "ij"}, "cdefghij", 2},
+		{"single large write", 4, []string{"abcdefghij"}, "ghij", 6},
+		{"many small writes", 4, strings.Split("abcdefghijklmnop", ""), "mnop", 12},
+		{"large write after small", 4, []string{"ab", "cdefgh", "i"}, "fghi", 5},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			buffer := &OutputBuffer{Limit: test.limit}
+			for _, write := range test.writes {
+				if n, err := buffer.Write([]byte(write)); n != len(write) || err != nil {
+					t.Fatalf("got %d, %v, expected %d", n, err, len(write))
+				}
+			}
+			if got := buffer.String(); got != test.expected {
+				t.Errorf("got %q, expected %q", got, test.expected)
+			}
+			if got := string(buffer.Bytes()); got != test.expected {
+				t.Errorf("Bytes: got %q, expected %q", got, test.expected)
+			}
+			if got := buffer.Len(); got != len(test.expected) {
+				t.Errorf("Len: got %d, expected %d", got, len(test.expected))
+			}
+			if got := buffer.Discarded(); got != test.discarded {
+				t.Errorf("Discarded: got %d, expected %d", got, test.discarded)
+			}
+		})
+	}
+}
+
+func TestOutputBufferDefaultLimit(t *testing.T) {
+	var buffer OutputBuffer
+	chunk := bytes.Repeat([]byte("0123456789abcdef"), 1024)
+	total := 0
+	for total <= DefaultOutputLimit+len(chunk) {
+		_, _ = buffer.Write(chunk)
+		total += len(chunk)
+	}
+
+	if got := buffer.Len(); got != DefaultOutputLimit {
+		t.Errorf("got %d bytes, expected %d", got, DefaultOutputLimit)
+	}
+	if got := buffer.Discarded(); got != int64(total-DefaultOutputLimit) {
+		t.Errorf("got %d discarded, expected %d", got, total-DefaultOutputLimit)
+	}
+	if data := buffer.Bytes(); !bytes.HasSuffix(data, chunk) {
+		t.Error("expected the most recent data to be kept")
+	}
+}
+
+func TestOutputBufferConcurrentWrites(t *testing.T) {
+	// lineLength is the length of "writer 0 00000\n"
+	const writers, lines, lineLength = 8, 500, 15
+	buffer := &OutputBuffer{Limit: 100 * lineLength}
+
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for k := 0; k < lines; k++ {
+				_, _ = fmt.Fprintf(buffer, "writer %d %05d\n", i, k)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	data := buffer.String()
+	if len(data) != buffer.Limit {
+		t.Errorf("got %d bytes, expected %d", len(data), buffer.Limit)
+	}
+	if got := buffer.Discarded() + int64(len(data)); got != writers*lines*lineLength {
+		t.Errorf("got %d bytes written, expected %d", got, writers*lines*lineLength)
+	}
+	// every write is kept whole, so the kept data consists of whole lines
+	for _, line := range strings.Split(strings.TrimSuffix(data, "\n"), "\n") {
+		var i, k int
+		if _, err := fmt.Sscanf(line, "writer %d %05d", &i, &k); err != nil || len(line) != lineLength-1 {
+			t.Errorf("broken line %q", line)
+		}
+	}
+}
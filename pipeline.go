@@ -1,22 +1,37 @@
 package ci
 
-import "strings"
+import (
+	"strings"
+	"time"
+)
 
 // Pipelines defines a collection of pipelines
 type Pipelines []*Pipeline
 
 // Pipeline defines a single execution tree
 type Pipeline struct {
-	Name  string
-	Desc  string
-	Steps []Step
+	Name    string
+	Desc    string
+	Timeout time.Duration
+	Steps   []Step
 }
 
 // Stage defines a set of steps to be executed
 type Stage struct {
-	Name     string
-	Parallel bool
-	Steps    []Step
+	Name        string
+	Parallel    bool
+	MaxParallel int
+	Timeout     time.Duration
+	Retry       Retry
+	// ContinueOnError runs the remaining steps after a failure
+	ContinueOnError bool
+	// AllowFailure ignores the failure of the stage
+	AllowFailure bool
+	// When defines whether the stage runs after a failure
+	When Condition
+	// Needs lists the names of sibling stages that must succeed first
+	Needs []string
+	Steps []Step
 }
 
 // Step defines an operation that is done in the execution tree
@@ -40,6 +55,7 @@ func (pipeline *Pipeline) Task() *Task {
 	task := &Task{}
 	task.Name = pipeline.Name
 	task.Desc = pipeline.Desc
+	task.Timeout = pipeline.Timeout
 	task.AddSteps(pipeline.Steps)
 	return task
 }
@@ -48,5 +64,12 @@ func (pipeline *Pipeline) Task() *Task {
 func (stage *Stage) Setup(parent *Task) {
 	task := parent.Subtask(stage.Name)
 	task.Parallel = stage.Parallel
+	task.MaxParallel = stage.MaxParallel
+	task.Timeout = stage.Timeout
+	task.Retry = stage.Retry
+	task.ContinueOnError = stage.ContinueOnError
+	task.AllowFailure = stage.AllowFailure
+	task.When = stage.When
+	task.Needs = stage.Needs
 	task.AddSteps(stage.Steps)
 }
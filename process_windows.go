@@ -0,0 +1,20 @@
+//go:build windows
+// +build windows
+
+package ci
+
+import (
+	"os/exec"
+	"strconv"
+)
+
+// setProcessGroup is a no-op, taskkill finds the children by itself.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// killProcessGroup kills the command and all of its children.
+func killProcessGroup(cmd *exec.Cmd) {
+	if cmd.Process == nil {
+		return
+	}
+	_ = exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(cmd.Process.Pid)).Run()
+}
@@ -0,0 +1,99 @@
+package ci
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// registry contains the step kinds that can be created by name.
+var registry struct {
+	mu    sync.RWMutex
+	kinds map[string]func() Step
+	types map[reflect.Type]string
+}
+
+func init() {
+	RegisterStep("stage", func() Step { return &Stage{} })
+	RegisterStep("run", func() Step { return &Run{} })
+	RegisterStep("set-env", func() Step { return &SetEnv{} })
+	RegisterStep("when-env", func() Step { return &WhenEnv{} })
+	RegisterStep("when-env-set", func() Step { return &WhenEnvSet{} })
+	RegisterStep("create-temp-dir", func() Step { return &CreateTempDir{} })
+	RegisterStep("change-dir", func() Step { return &ChangeDir{} })
+	RegisterStep("copy", func() Step { return &Copy{} })
+	RegisterStep("remove", func() Step { return &Remove{} })
+	RegisterStep("matrix", func() Step { return &Matrix{} })
+	RegisterStep("cached", func() Step { return &Cached{} })
+	RegisterStep("artifact", func() Step { return &Artifact{} })
+	RegisterStep("use-artifact", func() Step { return &UseArtifact{} })
+	RegisterStep("go-test", func() Step { return &GoTest{} })
+}
+
+// RegisterStep makes a step kind available to declarative pipeline files.
+//
+// factory must return a pointer to a new struct, its exported fields
+// are filled from the file. RegisterStep panics when the kind is
+// already registered or factory returns something else.
+func RegisterStep(kind string, factory func() Step) {
+	if kind == "" {
+		panic("ci: RegisterStep with an empty kind")
+	}
+
+	typ := reflect.TypeOf(factory())
+	if typ == nil || typ.Kind() != reflect.Ptr || typ.Elem().Kind() != reflect.Struct {
+		panic(fmt.Sprintf("ci: RegisterStep %q factory must return a pointer to a struct, got %v", kind, typ))
+	}
+
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	if registry.kinds == nil {
+		registry.kinds = map[string]func() Step{}
+		registry.types = map[reflect.Type]string{}
+	}
+	if _, exists := registry.kinds[kind]; exists {
+		panic(fmt.Sprintf("ci: RegisterStep called twice for %q", kind))
+	}
+	if existing, exists := registry.types[typ]; exists {
+		panic(fmt.Sprintf("ci: RegisterStep %q type %v is already registered as %q", kind, typ, existing))
+	}
+
+	registry.kinds[kind] = factory
+	registry.types[typ] = kind
+}
+
+// NewStep creates a step of a registered kind.
+func NewStep(kind string) (Step, bool) {
+	registry.mu.RLock()
+	factory, ok := registry.kinds[kind]
+	registry.mu.RUnlock()
+
+	if !ok {
+		return nil, false
+	}
+	return factory(), true
+}
+
+// StepKind returns the registered kind of the step.
+func StepKind(step Step) (string, bool) {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+
+	kind, ok := registry.types[reflect.TypeOf(step)]
+	return kind, ok
+}
+
+// StepKinds returns the sorted registered kinds.
+func StepKinds() []string {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+
+	kinds := make([]string, 0, len(registry.kinds))
+	for kind := range registry.kinds {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
@@ -0,0 +1,179 @@
+// Package report writes the results of a task tree in formats understood by other tools.
+package report
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/loov/ci"
+)
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Errors   int              `xml:"errors,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Errors    int             `xml:"errors,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Time      string          `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr,omitempty"`
+	Cases     []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Skipped   *junitMessage `xml:"skipped"`
+	Failure   *junitMessage `xml:"failure"`
+	Error     *junitMessage `xml:"error"`
+	Stdout    string        `xml:"system-out,omitempty"`
+	Stderr    string        `xml:"system-err,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr,omitempty"`
+	Type    string `xml:"type,attr,omitempty"`
+	Text    string `xml:",chardata"`
+}
+
+// JUnit writes the task tree as JUnit XML.
+//
+// Every task with subtasks is written as a testsuite,
+// which contains a testcase for each of its leaf subtasks.
+// Failing tasks are reported as failures, timeouts as errors and
+// tasks that were skipped, did not run or whose failure is allowed as skipped.
+func JUnit(w io.Writer, root *ci.Task) error {
+	status := root.Status()
+	suites := junitTestSuites{
+		Name: root.Name,
+		Time: seconds(duration(status)),
+	}
+	collectSuites(&suites, root)
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "\t")
+	if err := enc.Encode(suites); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// collectSuites adds the testsuite of task and its descendants to suites.
+func collectSuites(suites *junitTestSuites, task *ci.Task) {
+	subtasks := task.Subtasks()
+	if len(subtasks) == 0 {
+		return
+	}
+
+	status := task.Status()
+	suite := junitTestSuite{
+		Name: task.Path(),
+		Time: seconds(duration(status)),
+	}
+	if !status.Started.IsZero() {
+		suite.Timestamp = status.Started.Format("2006-01-02T15:04:05")
+	}
+
+	for _, subtask := range subtasks {
+		if len(subtask.Subtasks()) > 0 {
+			continue
+		}
+
+		testcase := newTestCase(task, subtask)
+		suite.Tests++
+		switch {
+		case testcase.Failure != nil:
+			suite.Failures++
+		case testcase.Error != nil:
+			suite.Errors++
+		case testcase.Skipped != nil:
+			suite.Skipped++
+		}
+		suite.Cases = append(suite.Cases, testcase)
+	}
+
+	if suite.Tests > 0 {
+		suites.Tests += suite.Tests
+		suites.Failures += suite.Failures
+		suites.Errors += suite.Errors
+		suites.Skipped += suite.Skipped
+		suites.Suites = append(suites.Suites, suite)
+	}
+
+	for _, subtask := range subtasks {
+		collectSuites(suites, subtask)
+	}
+}
+
+// newTestCase creates the testcase of a leaf task.
+func newTestCase(parent, task *ci.Task) junitTestCase {
+	status := task.Status()
+	stdout, stderr := task.Output()
+
+	testcase := junitTestCase{
+		Name:      task.Name,
+		Classname: parent.Path(),
+		Time:      seconds(duration(status)),
+		Stdout:    stdout.String(),
+		Stderr:    stderr.String(),
+	}
+
+	message := errorMessage(status)
+	switch status.State() {
+	case "error":
+		testcase.Failure = &junitMessage{Message: message, Type: "error", Text: message}
+	case "timeout":
+		testcase.Error = &junitMessage{Message: message, Type: "timeout", Text: message}
+	case "allowed-failure":
+		testcase.Skipped = &junitMessage{Message: "failure allowed: " + message}
+	case "skipped":
+		testcase.Skipped = &junitMessage{Message: "skipped"}
+	case "pending", "running":
+		testcase.Skipped = &junitMessage{Message: "did not run"}
+	}
+	return testcase
+}
+
+// errorMessage returns the error of the last attempt.
+func errorMessage(status ci.TaskStatus) string {
+	if n := len(status.Attempts); n > 0 && status.Attempts[n-1].Err != nil {
+		message := status.Attempts[n-1].Err.Error()
+		if n > 1 {
+			message = fmt.Sprintf("%s (attempt %d)", message, n)
+		}
+		return message
+	}
+	if status.ExecError != nil {
+		return status.ExecError.Error()
+	}
+	return ""
+}
+
+// duration returns how long the task ran.
+func duration(status ci.TaskStatus) time.Duration {
+	if status.Started.IsZero() || status.Finished.IsZero() {
+		return 0
+	}
+	return status.Finished.Sub(status.Started)
+}
+
+func seconds(duration time.Duration) string {
+	return fmt.Sprintf("%.3f", duration.Seconds())
+}
@@ -0,0 +1,116 @@
+package report
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/loov/ci"
+)
+
+// leaf adds a subtask that runs fn.
+func leaf(parent *ci.Task, name string, fn func(ctx context.Context) error) *ci.Task {
+	task := parent.Subtask("%s", name)
+	task.Exec = func(_, subcontext *ci.Context) error { return fn(subcontext) }
+	return task
+}
+
+func TestJUnit(t *testing.T) {
+	global, err := ci.NewGlobalContext(context.Background(), ".", ci.NewStdOutput(ioutil.Discard, ioutil.Discard))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer global.Cleanup()
+	global.Quiet = true
+
+	root := &ci.Task{Name: "Default", ContinueOnError: true}
+	build := root.Subtask("Build")
+	leaf(build, "compile", func(context.Context) error { return nil })
+
+	test := root.Subtask("Test")
+	test.ContinueOnError = true
+	leaf(test, "pass", func(context.Context) error { return nil })
+	leaf(test, "fail", func(context.Context) error { return errors.New("assertion failed") })
+	slow := leaf(test, "slow", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	slow.Timeout = time.Millisecond
+	leaf(test, "flaky", func(context.Context) error { return errors.New("flaked") }).AllowFailure = true
+	leaf(test, "skip", func(context.Context) error { return ci.ErrSkip })
+	unit := test.Subtask("Unit")
+	leaf(unit, "one", func(context.Context) error { return nil })
+
+	if err := root.Run(&global.Context); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	var buf bytes.Buffer
+	if err := JUnit(&buf, root); err != nil {
+		t.Fatal(err)
+	}
+
+	var suites junitTestSuites
+	if err := xml.Unmarshal(buf.Bytes(), &suites); err != nil {
+		t.Fatalf("invalid XML: %v\n%s", err, buf.String())
+	}
+
+	if suites.Name != "Default" || suites.Tests != 7 || suites.Failures != 1 || suites.Errors != 1 || suites.Skipped != 2 {
+		t.Errorf("got %q with %d tests, %d failures, %d errors, %d skipped, expected Default with 7, 1, 1, 2",
+			suites.Name, suites.Tests, suites.Failures, suites.Errors, suites.Skipped)
+	}
+
+	var names []string
+	byName := map[string]junitTestSuite{}
+	for _, suite := range suites.Suites {
+		names = append(names, suite.Name)
+		byName[suite.Name] = suite
+	}
+	expectedNames := []string{"Default/Build", "Default/Test", "Default/Test/Unit"}
+	if len(names) != len(expectedNames) {
+		t.Fatalf("got suites %q, expected %q", names, expectedNames)
+	}
+	for i := range names {
+		if names[i] != expectedNames[i] {
+			t.Errorf("got suites %q, expected %q", names, expectedNames)
+			break
+		}
+	}
+
+	suite := byName["Default/Test"]
+	if suite.Tests != 5 || suite.Failures != 1 || suite.Errors != 1 || suite.Skipped != 2 {
+		t.Errorf("got %d tests, %d failures, %d errors, %d skipped in %q, expected 5, 1, 1, 2",
+			suite.Tests, suite.Failures, suite.Errors, suite.Skipped, suite.Name)
+	}
+
+	cases := map[string]junitTestCase{}
+	for _, testcase := range suite.Cases {
+		cases[testcase.Name] = testcase
+		if testcase.Classname != "Default/Test" {
+			t.Errorf("%s: got classname %q, expected Default/Test", testcase.Name, testcase.Classname)
+		}
+	}
+
+	if c := cases["pass"]; c.Failure != nil || c.Error != nil || c.Skipped != nil {
+		t.Errorf("pass: got %+v, expected a passing testcase", c)
+	}
+	if c := cases["fail"]; c.Failure == nil || c.Failure.Message != "assertion failed" || c.Failure.Type != "error" {
+		t.Errorf("fail: got %+v, expected a failure", c.Failure)
+	}
+	if c := cases["slow"]; c.Error == nil || c.Error.Type != "timeout" {
+		t.Errorf("slow: got %+v, expected a timeout error", c.Error)
+	}
+	if c := cases["flaky"]; c.Skipped == nil || c.Skipped.Message != "failure allowed: flaked" {
+		t.Errorf("flaky: got %+v, expected a skipped allowed failure", c.Skipped)
+	}
+	if c := cases["skip"]; c.Skipped == nil || c.Skipped.Message != "skipped" {
+		t.Errorf("skip: got %+v, expected skipped", c.Skipped)
+	}
+	if c := byName["Default/Test/Unit"]; c.Tests != 1 || c.Cases[0].Name != "one" {
+		t.Errorf("got %+v, expected the nested stage as a separate testsuite", c)
+	}
+}
@@ -0,0 +1,73 @@
+package ci
+
+import (
+	"context"
+	"time"
+)
+
+// Retry defines how a failing task is retried.
+type Retry struct {
+	// Count is the number of retries after the first attempt
+	Count int
+	// Backoff defines the delay between attempts, nil means no delay
+	Backoff Backoff
+}
+
+// Backoff returns the delay before the n-th retry, n starts from 1.
+type Backoff func(n int) time.Duration
+
+// FixedBackoff waits the same delay before every retry.
+func FixedBackoff(delay time.Duration) Backoff {
+	return func(n int) time.Duration { return delay }
+}
+
+// ExponentialBackoff doubles the delay for every retry, up to max.
+func ExponentialBackoff(initial, max time.Duration) Backoff {
+	return func(n int) time.Duration {
+		delay := initial
+		for i := 1; i < n && delay < max; i++ {
+			if delay > max/2 {
+				// doubling would overflow
+				delay = max
+				break
+			}
+			delay *= 2
+		}
+		if delay > max {
+			delay = max
+		}
+		return delay
+	}
+}
+
+// Delay returns the delay before the n-th retry.
+func (retry Retry) Delay(n int) time.Duration {
+	if retry.Backoff == nil {
+		return 0
+	}
+	return retry.Backoff(n)
+}
+
+// Attempt describes a single run of a task.
+type Attempt struct {
+	Started  time.Time
+	Finished time.Time
+	Err      error
+}
+
+// sleep waits for the delay or until ctx is cancelled.
+func sleep(ctx context.Context, delay time.Duration) error {
+	if delay <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
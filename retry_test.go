@@ -0,0 +1,67 @@
+package ci
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestExponentialBackoff(t *testing.T) {
+	tests := []struct {
+		initial, max time.Duration
+		n            int
+		expected     time.Duration
+	}{
+		{time.Second, time.Minute, 1, time.Second},
+		{time.Second, time.Minute, 2, 2 * time.Second},
+		{time.Second, time.Minute, 3, 4 * time.Second},
+		{time.Second, time.Minute, 6, 32 * time.Second},
+		{time.Second, time.Minute, 7, time.Minute},
+		{time.Second, time.Minute, 1000, time.Minute},
+		{time.Minute, time.Second, 1, time.Second},
+		{0, time.Second, 5, 0},
+		{time.Second, math.MaxInt64, 100, math.MaxInt64},
+	}
+
+	for _, test := range tests {
+		got := ExponentialBackoff(test.initial, test.max)(test.n)
+		if got != test.expected {
+			t.Errorf("ExponentialBackoff(%v, %v)(%d): got %v, expected %v", test.initial, test.max, test.n, got, test.expected)
+		}
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		retry    Retry
+		n        int
+		expected time.Duration
+	}{
+		{Retry{Count: 3}, 1, 0},
+		{Retry{Count: 3, Backoff: FixedBackoff(time.Second)}, 1, time.Second},
+		{Retry{Count: 3, Backoff: FixedBackoff(time.Second)}, 3, time.Second},
+		{Retry{Count: 3, Backoff: ExponentialBackoff(time.Second, time.Minute)}, 3, 4 * time.Second},
+	}
+
+	for _, test := range tests {
+		if got := test.retry.Delay(test.n); got != test.expected {
+			t.Errorf("Delay(%d): got %v, expected %v", test.n, got, test.expected)
+		}
+	}
+}
+
+func TestSleepCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := sleep(ctx, time.Minute); err != context.Canceled {
+		t.Errorf("got %v, expected %v", err, context.Canceled)
+	}
+	if err := sleep(ctx, 0); err != context.Canceled {
+		t.Errorf("got %v, expected %v", err, context.Canceled)
+	}
+	if err := sleep(context.Background(), time.Millisecond); err != nil {
+		t.Errorf("got %v, expected no error", err)
+	}
+}
@@ -1,6 +1,8 @@
 package ci
 
 import (
+	"errors"
+	"io"
 	"os"
 	"os/exec"
 	"strings"
@@ -10,18 +12,34 @@ import (
 type Run struct {
 	Command string
 	Args    []string
+	Retry   Retry
 }
 
 // Setup sets up the step
 func (run *Run) Setup(parent *Task) {
 	task := parent.Subtask("run %q", run)
-	task.Exec = func(_, subcontext *Context) error {
-		subcontext.Logger.Printf("run %q\n", run)
-		cmd := exec.Command(run.Command, run.Args...)
+	task.Retry = run.Retry
+	task.Exec = func(context, subcontext *Context) error {
+		if context.Global.DryRun {
+			dir := subcontext.WorkingDir
+			if dir == "" {
+				dir, _ = os.Getwd()
+			}
+			context.Logger.Printf("would run %q in %q", run, dir)
+			return nil
+		}
+
+		context.Logger.Printf("run %q\n", run)
+		cmd := exec.CommandContext(subcontext, run.Command, run.Args...)
 		cmd.Dir = subcontext.WorkingDir
 		cmd.Env = subcontext.Env
-		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
-		return cmd.Run()
+
+		// output goes through the stage logger, so lines are prefixed by the stage path
+		var flush func()
+		cmd.Stdout, cmd.Stderr, flush = task.CommandOutput(context)
+		defer flush()
+
+		return runCommand(subcontext, cmd)
 	}
 }
 
@@ -32,3 +50,103 @@ func (run *Run) String() string {
 	args = append(args, run.Args...)
 	return strings.Join(args, " ")
 }
+
+// runCommand runs cmd and kills its process group when context is cancelled.
+func runCommand(context *Context, cmd *exec.Cmd) error {
+	global := context.Global
+	if err := global.startProcess(cmd); err != nil {
+		return err
+	}
+	defer global.finishProcess(cmd)
+
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-context.Done():
+			killProcessGroup(cmd)
+		case <-done:
+		}
+	}()
+
+	err := cmd.Wait()
+	close(done)
+
+	if ctxerr := context.Err(); ctxerr != nil {
+		return ctxerr
+	}
+	return err
+}
+
+// ErrKilled is returned for commands started after KillProcesses.
+var ErrKilled = errors.New("killed")
+
+// KillProcesses kills the process groups of all running commands,
+// commands started afterwards fail with ErrKilled.
+//
+// Commands run in their own process groups, so they do not receive
+// the interrupts of the terminal and must be killed explicitly.
+func (context *GlobalContext) KillProcesses() {
+	context.processes.mu.Lock()
+	defer context.processes.mu.Unlock()
+
+	context.processes.killed = true
+	for cmd := range context.processes.running {
+		killProcessGroup(cmd)
+	}
+}
+
+// startProcess starts cmd in a new process group and tracks it until finishProcess.
+func (context *GlobalContext) startProcess(cmd *exec.Cmd) error {
+	context.processes.mu.Lock()
+	defer context.processes.mu.Unlock()
+
+	if context.processes.killed {
+		return ErrKilled
+	}
+	setProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	if context.processes.running == nil {
+		context.processes.running = map[*exec.Cmd]struct{}{}
+	}
+	context.processes.running[cmd] = struct{}{}
+	return nil
+}
+
+// finishProcess stops tracking cmd.
+func (context *GlobalContext) finishProcess(cmd *exec.Cmd) {
+	context.processes.mu.Lock()
+	defer context.processes.mu.Unlock()
+
+	delete(context.processes.running, cmd)
+}
+
+// CommandOutput returns writers that capture the command output into the task,
+// and echo it through the logger output unless the context is quiet.
+//
+// flush must be called after the command has finished.
+func (task *Task) CommandOutput(context *Context) (stdout, stderr io.Writer, flush func()) {
+	stdout, stderr = &task.stdout, &task.stderr
+	if observer := context.Global.Observer; observer != nil {
+		stdout = io.MultiWriter(stdout, &outputObserver{task, "stdout", observer})
+		stderr = io.MultiWriter(stderr, &outputObserver{task, "stderr", observer})
+	}
+	if context.Global.Quiet {
+		return stdout, stderr, func() {}
+	}
+
+	echoOut, echoErr := context.Logger.Output()
+	flush = func() {
+		flushWriter(echoOut)
+		flushWriter(echoErr)
+	}
+	return io.MultiWriter(echoOut, stdout), io.MultiWriter(echoErr, stderr), flush
+}
+
+// flushWriter flushes w, when it buffers partial lines.
+func flushWriter(w io.Writer) {
+	if flusher, ok := w.(interface{ Flush() error }); ok {
+		_ = flusher.Flush()
+	}
+}
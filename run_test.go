@@ -0,0 +1,50 @@
+package ci
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestKillProcesses(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sleep")
+	}
+	global, cleanup := newTestContext(t)
+	defer cleanup()
+
+	root := &Task{Name: "root"}
+	root.AddSteps([]Step{&Run{Command: "sleep", Args: []string{"60"}}})
+
+	done := make(chan error, 1)
+	go func() { done <- root.Run(&global.Context) }()
+
+	// wait for the command to start
+	for start := time.Now(); ; time.Sleep(time.Millisecond) {
+		global.processes.mu.Lock()
+		running := len(global.processes.running)
+		global.processes.mu.Unlock()
+		if running > 0 {
+			break
+		}
+		if time.Since(start) > 10*time.Second {
+			t.Fatal("command did not start")
+		}
+	}
+
+	global.KillProcesses()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("expected the killed command to fail")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("command was not killed")
+	}
+
+	again := &Task{Name: "again"}
+	again.AddSteps([]Step{&Run{Command: "true"}})
+	if err := again.Run(&global.Context); err != ErrKilled {
+		t.Errorf("got %v, expected %v", err, ErrKilled)
+	}
+}
@@ -1,10 +1,11 @@
 package ci
 
 import (
-	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"strings"
 	"sync"
 	"time"
 
@@ -14,19 +15,39 @@ import (
 // ErrSkip is used to skip a particular task, without terminating execution
 var ErrSkip = errors.New("skip")
 
+// Condition defines when a task runs, depending on its earlier siblings.
+type Condition int
+
+const (
+	// OnSuccess runs the task when the earlier siblings have succeeded
+	OnSuccess Condition = iota
+	// OnFailure runs the task only when an earlier sibling has failed
+	OnFailure
+	// Always runs the task regardless of failures and cancellation,
+	// after cancellation it is limited to GlobalContext.CleanupTimeout
+	Always
+)
+
 // TaskStatus defines the status for the Task.
 type TaskStatus struct {
 	Started  time.Time
 	Finished time.Time
 
-	Running   bool
-	Skipped   bool
-	Done      bool
-	Errored   bool
+	Running  bool
+	Skipped  bool
+	Done     bool
+	Errored  bool
+	TimedOut bool
+	// Cached is set when the results were restored from cache
+	Cached bool
+	// FailureAllowed is set when the task failed, but the failure was ignored
+	FailureAllowed bool
+	// Resumed is set when the task was skipped, because it succeeded in the resumed run
+	Resumed   bool
 	ExecError error
 
-	Stderr bytes.Buffer
-	Stdout bytes.Buffer
+	// Attempts contains the finished runs of the task
+	Attempts []Attempt
 }
 
 // Task defines the execution tree.
@@ -34,15 +55,46 @@ type Task struct {
 	Name     string
 	Desc     string
 	Parallel bool
+	// MaxParallel limits the number of concurrently running leaf tasks
+	// inside a parallel task, 0 means unlimited
+	MaxParallel int
+	// Timeout limits the duration of a single attempt, including subtasks
+	Timeout time.Duration
+	// Retry defines how many times the task is rerun on failure
+	Retry Retry
+	// AllowFailure records a failure of the task without failing the parent
+	AllowFailure bool
+	// ContinueOnError runs the remaining subtasks after one of them fails,
+	// the error is returned after all of them have finished
+	ContinueOnError bool
+	// When defines whether the task runs after a sibling has failed
+	When Condition
+	// Needs lists the names of sibling tasks that must succeed before this task starts,
+	// in a sequential parent the siblings without Needs still run in the declared order
+	Needs []string
+	// Prepare marks tasks that set up the environment for their siblings,
+	// Filter can keep them when a sibling is selected
+	Prepare bool
 
 	// Exec is executed before Tasks,
 	// where context is the callers context and
 	// subcontext is the context used to execute sub tasks
-	Exec  func(context, subcontext *Context) error
+	Exec func(context, subcontext *Context) error
+	// Tasks are the subtasks, steps that report results as subtasks
+	// replace them while running, use Subtasks to read them concurrently
 	Tasks []*Task
 
+	parent  *Task
+	segment string
+	// reported is set when Exec creates the subtasks to describe its results,
+	// such subtasks are not run
+	reported bool
+
 	mu     sync.Mutex
 	status TaskStatus
+
+	stdout OutputBuffer
+	stderr OutputBuffer
 }
 
 // Start marks this task as started.
@@ -64,15 +116,74 @@ func (status *TaskStatus) Skip() {
 	status.Skipped = true
 }
 
+// State returns a short description of the status.
+func (status *TaskStatus) State() string {
+	switch {
+	case status.Running:
+		return "running"
+	case status.Skipped:
+		return "skipped"
+	case status.Resumed:
+		return "resumed"
+	case status.Cached:
+		return "cached"
+	case status.FailureAllowed:
+		return "allowed-failure"
+	case status.TimedOut:
+		return "timeout"
+	case status.Errored:
+		return "error"
+	case status.Done:
+		return "done"
+	default:
+		return "pending"
+	}
+}
+
 // Subtask creates a new subtask with a name
 func (task *Task) Subtask(name string, args ...interface{}) *Task {
 	subtask := &Task{
-		Name: fmt.Sprintf(name, args...),
+		Name:   fmt.Sprintf(name, args...),
+		parent: task,
 	}
+
+	// number duplicate names, so that paths stay unique
+	subtask.segment = escapeSegment(subtask.Name)
+	duplicates := 0
+	for _, sibling := range task.Tasks {
+		if sibling.Name == subtask.Name {
+			duplicates++
+		}
+	}
+	if duplicates > 0 {
+		subtask.segment = fmt.Sprintf("%s#%d", subtask.segment, duplicates+1)
+	}
+
 	task.Tasks = append(task.Tasks, subtask)
 	return subtask
 }
 
+// Path returns the slash separated names of the task and its ancestors,
+// e.g. "Default/Verification/Test".
+//
+// Siblings with the same name are numbered, starting from the second as "name#2".
+// Slashes in names are escaped, see MatchPath.
+// The path does not change when the tree is filtered.
+func (task *Task) Path() string {
+	if task.parent == nil {
+		return escapeSegment(task.Name)
+	}
+	return task.parent.Path() + "/" + task.segment
+}
+
+// segmentEscaper escapes the names of tasks in paths.
+var segmentEscaper = strings.NewReplacer("%", "%25", "/", "%2F")
+
+// escapeSegment escapes name, so that it does not contain path separators.
+func escapeSegment(name string) string {
+	return segmentEscaper.Replace(name)
+}
+
 // AddSteps sets up steps with this task as parent
 func (task *Task) AddSteps(steps []Step) {
 	for _, step := range steps {
@@ -81,16 +192,126 @@ func (task *Task) AddSteps(steps []Step) {
 }
 
 // Run executes the given task
+//
+// Run stops starting new subtasks once context is cancelled.
+// The Needs of the whole tree are validated before running a root task.
 func (task *Task) Run(context *Context) (err error) {
+	if err := context.Err(); err != nil {
+		return err
+	}
+	if task.parent == nil {
+		if err := task.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if task.resume(context) {
+		return nil
+	}
+
+	var before Env
+	workingDir := context.WorkingDir
+	if context.Global.Journal != nil {
+		before = context.Env.Clone()
+	}
+
+	observer := context.Global.observer()
+
 	task.updateStatus((*TaskStatus).Start)
-	defer task.updateStatus((*TaskStatus).Finish)
-	defer task.updateStatus(func(status *TaskStatus) { status.Errored = err != nil })
+	observer.TaskStarted(task)
+
+	for retry := 0; ; retry++ {
+		if retry > 0 {
+			if err := sleep(context, task.Retry.Delay(retry)); err != nil {
+				break
+			}
+			for _, subtask := range task.Tasks {
+				subtask.reset()
+			}
+			context.Warnf("retrying %v (attempt %d): %v", task.Name, retry+1, err)
+		}
+
+		started := time.Now()
+		err = task.attempt(context)
+		attempt := Attempt{
+			Started:  started,
+			Finished: time.Now(),
+			Err:      err,
+		}
+		task.updateStatus(func(status *TaskStatus) {
+			status.Attempts = append(status.Attempts, attempt)
+		})
+		observer.TaskAttempt(task, attempt)
+
+		if err == nil || retry >= task.Retry.Count || context.Err() != nil {
+			break
+		}
+	}
+
+	task.updateStatus(func(status *TaskStatus) {
+		status.Errored = err != nil && !status.TimedOut
+		status.FailureAllowed = err != nil && task.AllowFailure
+		status.Finish()
+	})
+	if err != nil {
+		observer.TaskErrored(task, err)
+	}
+	observer.TaskFinished(task)
+	task.record(context, before, workingDir, err)
+
+	if err != nil && task.AllowFailure {
+		context.Warnf("%v failed, but failure is allowed: %v", task.Name, err)
+		return nil
+	}
+	return err
+}
+
+// attempt runs the task once.
+func (task *Task) attempt(context *Context) error {
+	task.updateStatus(func(status *TaskStatus) {
+		status.Skipped = false
+		status.Cached = false
+		status.TimedOut = false
+		status.ExecError = nil
+	})
+
+	if task.leaf() {
+		release, err := acquireLimits(context)
+		if err != nil {
+			return err
+		}
+		defer release()
+	}
 
 	subcontext := context.Sub(task.Name)
+	ctx, cancel := withTimeout(subcontext.Context, task.Timeout)
+	defer cancel()
+	subcontext.Context = ctx
+
+	err := task.run(context, subcontext)
+	if ctx.Err() != nil && context.Err() == nil {
+		task.updateStatus(func(status *TaskStatus) { status.TimedOut = true })
+		return fmt.Errorf("%v timed out after %v", task.Name, task.Timeout)
+	}
+	return err
+}
+
+// leaf returns whether the task does the work itself, instead of running subtasks,
+// tasks that report their results as subtasks are leaves regardless of the results.
+func (task *Task) leaf() bool {
+	return task.reported || len(task.Tasks) == 0
+}
+
+func (task *Task) run(context, subcontext *Context) error {
 	if task.Exec != nil {
 		err := task.Exec(context, subcontext)
 		if err == ErrSkip {
 			task.updateStatus((*TaskStatus).Skip)
+			context.Global.observer().TaskSkipped(task)
+			return nil
+		}
+		if err == ErrCached {
+			task.updateStatus(func(status *TaskStatus) { status.Cached = true })
 			return nil
 		}
 		if err != nil {
@@ -101,24 +322,207 @@ func (task *Task) Run(context *Context) (err error) {
 		}
 	}
 
-	if !task.Parallel {
-		for _, subtask := range task.Tasks {
-			err := subtask.Run(subcontext)
+	if task.reported {
+		return nil
+	}
+	if task.Parallel || task.hasNeeds() {
+		return task.runParallel(subcontext)
+	}
+	return task.runSequential(subcontext)
+}
+
+// runSequential runs subtasks one after another.
+func (task *Task) runSequential(context *Context) error {
+	var errs Errors
+	var failed *Task
+	for _, subtask := range task.Tasks {
+		if subtask.When == OnSuccess {
+			if context.Err() != nil || (failed != nil && !task.ContinueOnError) {
+				continue
+			}
+		}
+
+		subcontext, release := conditionContext(context, subtask.When, failed, errs)
+		if subcontext == nil {
+			continue
+		}
+
+		err := subtask.Run(subcontext)
+		release()
+		if err != nil {
+			errs = append(errs, err)
+			if failed == nil {
+				failed = subtask
+			}
+		}
+	}
+
+	if len(errs) == 0 {
+		return context.Err()
+	}
+	return errs.Err()
+}
+
+// runParallel runs subtasks concurrently,
+// followed by the finally and on-failure subtasks in order.
+//
+// Subtasks with Needs start after the needed siblings have succeeded.
+// When task is not Parallel, subtasks without Needs start after all the earlier siblings
+// have finished and subtasks with Needs after the earlier Prepare siblings,
+// so that the environment is set up the same way as when running sequentially.
+func (task *Task) runParallel(context *Context) error {
+	if err := task.checkNeeds(); err != nil {
+		return err
+	}
+
+	var mu sync.Mutex
+	var errs Errors
+	var failed *Task
+	succeeded := map[*Task]bool{}
+
+	// channels and needs are keyed by task, because siblings may share a name
+	finished := map[*Task]chan struct{}{}
+	needs := map[*Task][]*Task{}
+	after := map[*Task][]*Task{}
+	var earlier []*Task
+	for _, subtask := range task.Tasks {
+		if subtask.When != OnSuccess {
+			continue
+		}
+		finished[subtask] = make(chan struct{})
+		for _, need := range subtask.Needs {
+			needs[subtask] = append(needs[subtask], task.subtask(need))
+		}
+		if !task.Parallel {
+			for _, sibling := range earlier {
+				if len(subtask.Needs) == 0 || sibling.Prepare {
+					after[subtask] = append(after[subtask], sibling)
+				}
+			}
+		}
+		earlier = append(earlier, subtask)
+	}
+
+	group, ctx := errgroup.WithContext(context.Context)
+	branch := *context
+	branch.Context = ctx
+	if task.MaxParallel > 0 {
+		branch.limits = withLimit(branch.limits, task.MaxParallel)
+	}
+
+	for _, subtask := range task.Tasks {
+		if subtask.When != OnSuccess {
+			continue
+		}
+
+		subtask := subtask
+		group.Go(func() error {
+			defer close(finished[subtask])
+
+			for _, sibling := range after[subtask] {
+				select {
+				case <-finished[sibling]:
+				case <-ctx.Done():
+					return nil
+				}
+			}
+
+			for _, need := range needs[subtask] {
+				select {
+				case <-finished[need]:
+				case <-ctx.Done():
+					return nil
+				}
+
+				mu.Lock()
+				ok := succeeded[need]
+				mu.Unlock()
+				if !ok {
+					context.Printf("skipping %v, because %v did not succeed", subtask.Name, need.Name)
+					subtask.updateStatus((*TaskStatus).Skip)
+					context.Global.observer().TaskSkipped(subtask)
+					return nil
+				}
+			}
+
+			err := subtask.Run(&branch)
+
+			mu.Lock()
 			if err != nil {
-				return err
+				errs = append(errs, err)
+				if failed == nil {
+					failed = subtask
+				}
+			} else {
+				succeeded[subtask] = true
+			}
+			mu.Unlock()
+
+			if task.ContinueOnError {
+				return nil
 			}
+			// the first failing subtask cancels its siblings
+			return err
+		})
+	}
+	if err := group.Wait(); err != nil && !task.ContinueOnError {
+		// only report the first error, the others are caused by cancellation
+		errs = Errors{err}
+	}
+
+	for _, subtask := range task.Tasks {
+		if subtask.When == OnSuccess {
+			continue
 		}
-		return nil
-	} else {
-		var group errgroup.Group
-		for _, subtask := range task.Tasks {
-			subtask := subtask
-			group.Go(func() error {
-				return subtask.Run(subcontext)
-			})
+
+		subcontext, release := conditionContext(context, subtask.When, failed, errs)
+		if subcontext == nil {
+			continue
 		}
-		return group.Wait()
+
+		err := subtask.Run(subcontext)
+		release()
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) == 0 {
+		return context.Err()
+	}
+	return errs.Err()
+}
+
+// Errors combines failures of multiple tasks.
+type Errors []error
+
+// Error returns all the error messages.
+func (errs Errors) Error() string {
+	messages := make([]string, 0, len(errs))
+	for _, err := range errs {
+		messages = append(messages, err.Error())
 	}
+	return strings.Join(messages, "; ")
+}
+
+// Err returns nil when there are no errors and the error itself when there is only one.
+func (errs Errors) Err() error {
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	default:
+		return errs
+	}
+}
+
+// withTimeout limits ctx to timeout, when timeout is positive.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
 }
 
 func (task *Task) updateStatus(fn func(*TaskStatus)) {
@@ -127,11 +531,40 @@ func (task *Task) updateStatus(fn func(*TaskStatus)) {
 	fn(&task.status)
 }
 
+// reset clears the status of the task and its subtasks.
+func (task *Task) reset() {
+	task.updateStatus(func(status *TaskStatus) { *status = TaskStatus{} })
+	for _, subtask := range task.Subtasks() {
+		subtask.reset()
+	}
+}
+
+// Subtasks returns a copy of Tasks, it is safe to call while the task is running.
+func (task *Task) Subtasks() []*Task {
+	task.mu.Lock()
+	defer task.mu.Unlock()
+	return append([]*Task(nil), task.Tasks...)
+}
+
+// setSubtasks replaces Tasks while the task may be observed.
+func (task *Task) setSubtasks(tasks []*Task) {
+	task.mu.Lock()
+	defer task.mu.Unlock()
+	task.Tasks = tasks
+}
+
 // Status reads the current task status.
 func (task *Task) Status() TaskStatus {
 	task.mu.Lock()
 	defer task.mu.Unlock()
-	return task.status
+	status := task.status
+	status.Attempts = append([]Attempt(nil), status.Attempts...)
+	return status
+}
+
+// Output returns the buffers capturing the output of the task.
+func (task *Task) Output() (stdout, stderr *OutputBuffer) {
+	return &task.stdout, &task.stderr
 }
 
 // PrintTo prints the execution tree
@@ -143,20 +576,40 @@ func (task *Task) PrintTo(w io.Writer, ident string) {
 	switch {
 	case status.Running:
 		stat = " R "
-		duration = formatDuration(time.Since(status.Started))
+		duration = FormatDuration(time.Since(status.Started))
 	case status.Skipped:
 		stat = " S "
-		duration = formatDuration(status.Finished.Sub(status.Started))
+		duration = FormatDuration(status.Finished.Sub(status.Started))
+	case status.Resumed:
+		stat = " P "
+		duration = FormatDuration(status.Finished.Sub(status.Started))
+	case status.Cached:
+		stat = " C "
+		duration = FormatDuration(status.Finished.Sub(status.Started))
+	case status.FailureAllowed:
+		stat = " A "
+		duration = FormatDuration(status.Finished.Sub(status.Started))
+	case status.TimedOut:
+		stat = " T "
+		duration = FormatDuration(status.Finished.Sub(status.Started))
 	case status.Errored:
 		stat = " E "
-		duration = formatDuration(status.Finished.Sub(status.Started))
+		duration = FormatDuration(status.Finished.Sub(status.Started))
 	case status.Done:
 		stat = "   "
-		duration = formatDuration(status.Finished.Sub(status.Started))
+		duration = FormatDuration(status.Finished.Sub(status.Started))
+	}
+
+	var attempt string
+	if status.Running && len(status.Attempts) > 0 {
+		attempt = fmt.Sprintf(" (attempt %d)", len(status.Attempts)+1)
+	} else if len(status.Attempts) > 1 {
+		attempt = fmt.Sprintf(" (attempt %d)", len(status.Attempts))
 	}
 
-	if len(task.Tasks) == 0 {
-		fmt.Fprintf(w, "%5s %s %s%s\n", duration, stat, ident, task.Name)
+	subtasks := task.Subtasks()
+	if len(subtasks) == 0 {
+		fmt.Fprintf(w, "%5s %s %s%s%s\n", duration, stat, ident, task.Name, attempt)
 		return
 	}
 	if task.Name != "" {
@@ -164,18 +617,22 @@ func (task *Task) PrintTo(w io.Writer, ident string) {
 		if task.Desc != "" {
 			desc = " " + task.Desc
 		}
+		if len(task.Needs) > 0 {
+			desc += " (needs " + strings.Join(task.Needs, ", ") + ")"
+		}
 
 		if task.Parallel {
-			fmt.Fprintf(w, "%5s %s %s%s:%s (parallel)\n", duration, stat, ident, task.Name, desc)
+			fmt.Fprintf(w, "%5s %s %s%s:%s (parallel)%s\n", duration, stat, ident, task.Name, desc, attempt)
 		} else {
-			fmt.Fprintf(w, "%5s %s %s%s:%s\n", duration, stat, ident, task.Name, desc)
+			fmt.Fprintf(w, "%5s %s %s%s:%s%s\n", duration, stat, ident, task.Name, desc, attempt)
 		}
 	}
-	for _, task := range task.Tasks {
+	for _, task := range subtasks {
 		task.PrintTo(w, ident+"    ")
 	}
 }
 
-func formatDuration(d time.Duration) string {
+// FormatDuration formats d rounded down to seconds.
+func FormatDuration(d time.Duration) string {
 	return d.Truncate(time.Second).String()
 }
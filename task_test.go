@@ -0,0 +1,241 @@
+package ci
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestContext creates a quiet global context, cleanup removes its temporary data.
+func newTestContext(t *testing.T) (global *GlobalContext, cleanup func()) {
+	t.Helper()
+	global, err := NewGlobalContext(context.Background(), ".", NewStdOutput(ioutil.Discard, ioutil.Discard))
+	if err != nil {
+		t.Fatal(err)
+	}
+	global.Quiet = true
+	return global, func() { _ = global.Cleanup() }
+}
+
+// testLeaf adds a subtask that runs fn.
+func testLeaf(parent *Task, name string, fn func() error) *Task {
+	task := parent.Subtask("%s", name)
+	task.Exec = func(context, subcontext *Context) error { return fn() }
+	return task
+}
+
+func succeed() error { return nil }
+func fail() error    { return errors.New("fail") }
+
+func TestRunParallelDuplicateNames(t *testing.T) {
+	global, cleanup := newTestContext(t)
+	defer cleanup()
+
+	root := &Task{Name: "V", Parallel: true}
+	first := root.Subtask("allow failure")
+	first.AllowFailure = true
+	testLeaf(first, "true", succeed)
+	second := root.Subtask("allow failure")
+	second.AllowFailure = true
+	testLeaf(second, "false", fail)
+
+	if err := root.Run(&global.Context); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expectState(t, first, "done")
+	expectState(t, second, "allowed-failure")
+}
+
+func expectState(t *testing.T, task *Task, expected string) {
+	t.Helper()
+	status := task.Status()
+	if state := status.State(); state != expected {
+		t.Errorf("%v: got %q, expected %q", task.Path(), state, expected)
+	}
+}
+
+func TestRunParallelSkipsFailedNeeds(t *testing.T) {
+	global, cleanup := newTestContext(t)
+	defer cleanup()
+
+	root := &Task{Name: "root", ContinueOnError: true}
+	build := testLeaf(root, "build", fail)
+	test := testLeaf(root, "test", succeed)
+	test.Needs = []string{"build"}
+	lint := testLeaf(root, "lint", succeed)
+
+	if err := root.Run(&global.Context); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	expectState(t, build, "error")
+	expectState(t, test, "skipped")
+	expectState(t, lint, "done")
+}
+
+func TestRunParallelNeedsOrder(t *testing.T) {
+	global, cleanup := newTestContext(t)
+	defer cleanup()
+
+	var mu sync.Mutex
+	var order []string
+	record := func(name string) func() error {
+		return func() error {
+			mu.Lock()
+			defer mu.Unlock()
+			order = append(order, name)
+			return nil
+		}
+	}
+
+	root := &Task{Name: "root", Parallel: true}
+	testLeaf(root, "test", record("test")).Needs = []string{"build"}
+	testLeaf(root, "build", record("build")).Needs = []string{"download"}
+	testLeaf(root, "download", record("download"))
+	testLeaf(root, "download", record("download#2"))
+
+	if err := root.Run(&global.Context); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	position := map[string]int{}
+	for i, name := range order {
+		position[name] = i
+	}
+	if len(order) != 4 {
+		t.Fatalf("got %v, expected all tasks to run", order)
+	}
+	if position["download"] > position["build"] || position["build"] > position["test"] {
+		t.Errorf("got %v, expected download, build, test order", order)
+	}
+}
+
+func TestRunParallelFailureCancelsSiblings(t *testing.T) {
+	global, cleanup := newTestContext(t)
+	defer cleanup()
+
+	root := &Task{Name: "root", Parallel: true}
+	testLeaf(root, "fail", fail)
+	wait := root.Subtask("wait")
+	wait.Exec = func(context, subcontext *Context) error {
+		<-subcontext.Done()
+		return subcontext.Err()
+	}
+	always := testLeaf(root, "finally", succeed)
+	always.When = Always
+
+	if err := root.Run(&global.Context); err == nil || err.Error() != "fail" {
+		t.Fatalf("got %v, expected the first failure", err)
+	}
+	expectState(t, always, "done")
+}
+
+func TestAlwaysAfterCancelHasCleanupTimeout(t *testing.T) {
+	global, cleanup := newTestContext(t)
+	defer cleanup()
+	global.CleanupTimeout = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	global.Context.Context = ctx
+
+	root := &Task{Name: "root"}
+	testLeaf(root, "interrupted", func() error {
+		cancel()
+		return nil
+	})
+	var cleanupErr error
+	always := root.Subtask("finally")
+	always.When = Always
+	always.Exec = func(context, subcontext *Context) error {
+		select {
+		case <-subcontext.Done():
+			cleanupErr = subcontext.Err()
+		case <-time.After(time.Minute):
+		}
+		return cleanupErr
+	}
+
+	if err := root.Run(&global.Context); err == nil {
+		t.Fatal("expected an error")
+	}
+	if cleanupErr != context.DeadlineExceeded {
+		t.Errorf("got %v, expected the cleanup to time out", cleanupErr)
+	}
+}
+
+func TestRunSequentialNeedsKeepsOrder(t *testing.T) {
+	global, cleanup := newTestContext(t)
+	defer cleanup()
+
+	var mu sync.Mutex
+	var order []string
+	record := func(name string) func() error {
+		return func() error {
+			mu.Lock()
+			defer mu.Unlock()
+			order = append(order, name)
+			return nil
+		}
+	}
+
+	root := &Task{Name: "root"}
+	root.Subtask("env").Exec = func(context, _ *Context) error {
+		time.Sleep(10 * time.Millisecond)
+		context.SetEnv("STAGE", "set")
+		return record("env")()
+	}
+	root.Tasks[0].Prepare = true
+	testLeaf(root, "build", record("build"))
+	lint := root.Subtask("lint")
+	lint.Exec = func(_, subcontext *Context) error {
+		if value, _ := subcontext.Env.Get("STAGE"); value != "set" {
+			t.Error("lint started before env was set")
+		}
+		return record("lint")()
+	}
+	testLeaf(root, "test", record("test")).Needs = []string{"build"}
+	testLeaf(root, "docs", record("docs"))
+
+	if err := root.Run(&global.Context); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	position := map[string]int{}
+	for i, name := range order {
+		position[name] = i
+	}
+	if len(order) != 5 {
+		t.Fatalf("got %v, expected all tasks to run", order)
+	}
+	if !(position["env"] < position["build"] && position["build"] < position["lint"] && position["lint"] < position["docs"]) {
+		t.Errorf("got %v, expected env, build, lint, docs in the declared order", order)
+	}
+	if position["test"] < position["build"] || position["test"] > position["docs"] {
+		t.Errorf("got %v, expected test after build and before docs", order)
+	}
+}
+
+func TestRunValidatesNeeds(t *testing.T) {
+	global, cleanup := newTestContext(t)
+	defer cleanup()
+
+	ran := false
+	root := &Task{Name: "root"}
+	testLeaf(root, "build", func() error {
+		ran = true
+		return nil
+	})
+	stage := root.Subtask("stage")
+	testLeaf(stage, "test", succeed).Needs = []string{"missing"}
+
+	if err := root.Run(&global.Context); err == nil {
+		t.Fatal("expected an error")
+	}
+	if ran {
+		t.Error("expected validation before running any task")
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package term
+
+import "os"
+
+// terminalSize is not supported, the size comes from $COLUMNS and $LINES.
+func terminalSize(file *os.File) (width, height int, ok bool) {
+	return 0, 0, false
+}
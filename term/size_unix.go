@@ -0,0 +1,22 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package term
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// terminalSize returns the number of columns and rows of the terminal file.
+func terminalSize(file *os.File) (width, height int, ok bool) {
+	var size struct {
+		Rows, Cols, Xpixel, Ypixel uint16
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, file.Fd(), uintptr(syscall.TIOCGWINSZ), uintptr(unsafe.Pointer(&size)))
+	if errno != 0 || size.Cols == 0 || size.Rows == 0 {
+		return 0, 0, false
+	}
+	return int(size.Cols), int(size.Rows), true
+}
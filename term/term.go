@@ -0,0 +1,289 @@
+// Package term renders a live task tree on a terminal.
+package term
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/loov/ci"
+)
+
+var spinner = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+// Renderer draws the task tree.
+//
+// In interactive mode the tree is redrawn in place using ANSI escape codes,
+// otherwise state changes are appended as lines.
+type Renderer struct {
+	// Interactive enables redrawing the tree in place
+	Interactive bool
+	// Interval is the delay between redraws
+	Interval time.Duration
+	// TailLines is the number of output lines shown for the focused task
+	TailLines int
+	// Width truncates lines to fit the terminal, 0 disables truncation
+	Width int
+	// Height limits the number of drawn lines to fit the terminal, 0 disables the limit
+	Height int
+	// Focus is the path of the task whose output is shown,
+	// when empty the most recently started running task is shown
+	Focus string
+
+	root *ci.Task
+	out  io.Writer
+	// terminal is used for updating Width and Height on resize
+	terminal *os.File
+
+	mu     sync.Mutex
+	frame  int
+	lines  int
+	states map[*ci.Task]string
+}
+
+// New creates a renderer for root, which is interactive when out is a terminal.
+func New(out io.Writer, root *ci.Task) *Renderer {
+	renderer := &Renderer{
+		Interactive: ci.IsTerminal(out),
+		Interval:    100 * time.Millisecond,
+		TailLines:   10,
+		Width:       100,
+
+		root:   root,
+		out:    out,
+		states: map[*ci.Task]string{},
+	}
+	if columns, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && columns > 0 {
+		renderer.Width = columns
+	}
+	if rows, err := strconv.Atoi(os.Getenv("LINES")); err == nil && rows > 0 {
+		renderer.Height = rows
+	}
+	if file, ok := out.(*os.File); ok && renderer.Interactive {
+		if width, height, ok := terminalSize(file); ok {
+			renderer.Width, renderer.Height = width, height
+			renderer.terminal = file
+		}
+	}
+	if !renderer.Interactive {
+		renderer.Interval = time.Second
+	}
+	return renderer
+}
+
+// Run redraws the tree until ctx is cancelled.
+func (renderer *Renderer) Run(ctx context.Context) error {
+	ticker := time.NewTicker(renderer.Interval)
+	defer ticker.Stop()
+
+	for {
+		renderer.Draw()
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			renderer.Draw()
+			return nil
+		}
+	}
+}
+
+// Write writes p above the tree, p should contain whole lines.
+func (renderer *Renderer) Write(p []byte) (int, error) {
+	renderer.mu.Lock()
+	defer renderer.mu.Unlock()
+
+	if !renderer.Interactive {
+		return renderer.out.Write(p)
+	}
+
+	var buf bytes.Buffer
+	renderer.erase(&buf)
+	buf.Write(p)
+	renderer.resize()
+	renderer.render(&buf)
+	_, err := renderer.out.Write(buf.Bytes())
+	return len(p), err
+}
+
+// Draw draws the current state of the tree.
+func (renderer *Renderer) Draw() {
+	renderer.mu.Lock()
+	defer renderer.mu.Unlock()
+
+	var buf bytes.Buffer
+	if renderer.Interactive {
+		renderer.frame++
+		renderer.erase(&buf)
+		renderer.resize()
+		renderer.render(&buf)
+	} else {
+		renderer.changes(&buf, renderer.root)
+	}
+	_, _ = renderer.out.Write(buf.Bytes())
+}
+
+// erase moves the cursor to the start of the previously drawn tree.
+func (renderer *Renderer) erase(buf *bytes.Buffer) {
+	if renderer.lines > 0 {
+		fmt.Fprintf(buf, "\x1b[%dA", renderer.lines)
+	}
+	buf.WriteString("\r\x1b[J")
+	renderer.lines = 0
+}
+
+// resize updates Width and Height, when the terminal has been resized.
+func (renderer *Renderer) resize() {
+	if renderer.terminal == nil {
+		return
+	}
+	if width, height, ok := terminalSize(renderer.terminal); ok {
+		renderer.Width, renderer.Height = width, height
+	}
+}
+
+// render writes the tree and the output tail of the focused task.
+//
+// Lines are truncated to Width and limited to Height,
+// so that erase can move back to the start of the tree.
+func (renderer *Renderer) render(buf *bytes.Buffer) {
+	var lines []string
+	var focus *ci.Task
+	var focusStarted time.Time
+
+	var walk func(task *ci.Task, ident string)
+	walk = func(task *ci.Task, ident string) {
+		status := task.Status()
+		lines = append(lines, renderer.format(task, status, ident))
+
+		if renderer.Focus != "" {
+			if task.Path() == renderer.Focus {
+				focus = task
+			}
+		}
+
+		subtasks := task.Subtasks()
+		if renderer.Focus == "" && len(subtasks) == 0 && status.Running && status.Started.After(focusStarted) {
+			focus, focusStarted = task, status.Started
+		}
+
+		for _, subtask := range subtasks {
+			walk(subtask, ident+"  ")
+		}
+	}
+	walk(renderer.root, "")
+
+	if focus != nil && renderer.TailLines > 0 {
+		stdout, stderr := focus.Output()
+		tail := lastLines(stdout.String()+stderr.String(), renderer.TailLines)
+		if len(tail) > 0 {
+			lines = append(lines, "", "── "+focus.Path())
+			lines = append(lines, tail...)
+		}
+	}
+
+	// the cursor ends up on the line after the tree
+	if limit := renderer.Height - 1; renderer.Height > 0 && len(lines) > limit {
+		if limit < 1 {
+			limit = 1
+		}
+		hidden := len(lines) - limit + 1
+		lines = append(lines[:limit-1], fmt.Sprintf("%6s … %d more lines", "", hidden))
+	}
+
+	for _, line := range lines {
+		buf.WriteString(renderer.truncate(line))
+		buf.WriteString("\x1b[K\n")
+	}
+	renderer.lines = len(lines)
+}
+
+// format formats a single tree line.
+func (renderer *Renderer) format(task *ci.Task, status ci.TaskStatus, ident string) string {
+	var marker, duration string
+	switch state := status.State(); state {
+	case "running":
+		marker = spinner[renderer.frame%len(spinner)]
+		duration = ci.FormatDuration(time.Since(status.Started))
+	case "pending":
+		marker = " "
+	default:
+		marker = markers[state]
+		duration = ci.FormatDuration(status.Finished.Sub(status.Started))
+	}
+
+	var suffix string
+	if n := len(status.Attempts); n > 1 || (status.Running && n > 0) {
+		if status.Running {
+			n++
+		}
+		suffix = fmt.Sprintf(" (attempt %d)", n)
+	}
+
+	return fmt.Sprintf("%6s %s %s%s%s", duration, marker, ident, task.Name, suffix)
+}
+
+var markers = map[string]string{
+	"done":            "✓",
+	"error":           "✗",
+	"timeout":         "T",
+	"skipped":         "-",
+	"cached":          "C",
+	"resumed":         "P",
+	"allowed-failure": "!",
+}
+
+// changes writes a line for every task whose state has changed since the last call.
+func (renderer *Renderer) changes(buf *bytes.Buffer, task *ci.Task) {
+	status := task.Status()
+	state := status.State()
+
+	previous, ok := renderer.states[task]
+	if !ok {
+		previous = "pending"
+	}
+	if state != previous {
+		renderer.states[task] = state
+
+		line := time.Now().Format("15:04:05") + " " + state + " " + task.Path()
+		if !status.Running && status.Done {
+			line += " (" + ci.FormatDuration(status.Finished.Sub(status.Started)) + ")"
+		}
+		buf.WriteString(line + "\n")
+	}
+
+	for _, subtask := range task.Subtasks() {
+		renderer.changes(buf, subtask)
+	}
+}
+
+func (renderer *Renderer) truncate(line string) string {
+	if renderer.Width <= 0 {
+		return line
+	}
+	runes := []rune(line)
+	if len(runes) <= renderer.Width {
+		return line
+	}
+	return string(runes[:renderer.Width-1]) + "…"
+}
+
+// lastLines returns the last n non-empty lines of text.
+func lastLines(text string, n int) []string {
+	lines := strings.Split(strings.TrimRight(text, "\n"), "\n")
+	if len(lines) == 1 && lines[0] == "" {
+		return nil
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, "\r")
+	}
+	return lines
+}
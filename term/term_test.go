@@ -0,0 +1,47 @@
+package term
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/loov/ci"
+)
+
+var rxEscape = regexp.MustCompile("\x1b\\[[0-9]*[A-Za-z]")
+
+func TestRenderFitsTerminal(t *testing.T) {
+	root := &ci.Task{Name: "Default"}
+	for i := 0; i < 20; i++ {
+		root.Subtask("a task with a name that is longer than the terminal %d", i)
+	}
+
+	var out bytes.Buffer
+	renderer := New(&out, root)
+	renderer.Interactive = true
+	renderer.Width = 30
+	renderer.Height = 8
+
+	renderer.Draw()
+	lines := strings.Split(strings.TrimSuffix(rxEscape.ReplaceAllString(out.String(), ""), "\n"), "\n")
+	if len(lines) != renderer.Height-1 {
+		t.Errorf("got %d lines, expected %d:\n%s", len(lines), renderer.Height-1, strings.Join(lines, "\n"))
+	}
+	for _, line := range lines {
+		line = strings.TrimPrefix(line, "\r")
+		if n := utf8.RuneCountInString(line); n > renderer.Width {
+			t.Errorf("line %q has %d runes, expected at most %d", line, n, renderer.Width)
+		}
+	}
+	if last := lines[len(lines)-1]; !strings.Contains(last, "15 more lines") {
+		t.Errorf("got %q, expected the number of hidden lines", last)
+	}
+
+	out.Reset()
+	renderer.Draw()
+	if !strings.HasPrefix(out.String(), "\x1b[7A") {
+		t.Errorf("got %q, expected to move up by the drawn lines", out.String())
+	}
+}
@@ -0,0 +1,319 @@
+// Package web implements an HTTP dashboard for watching a running pipeline.
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/loov/ci"
+)
+
+// Server serves the live state of a task tree.
+//
+// Server implements ci.Observer, it must be set as the GlobalContext
+// observer to stream updates to the browser.
+type Server struct {
+	root *ci.Task
+	mux  *http.ServeMux
+
+	mu      sync.Mutex
+	clients map[chan event]struct{}
+}
+
+var _ ci.Observer = (*Server)(nil)
+
+// New creates a dashboard for root.
+func New(root *ci.Task) *Server {
+	server := &Server{
+		root:    root,
+		mux:     http.NewServeMux(),
+		clients: map[chan event]struct{}{},
+	}
+
+	server.mux.HandleFunc("/", server.serveIndex)
+	server.mux.HandleFunc("/tree", server.serveTree)
+	server.mux.HandleFunc("/log", server.serveLog)
+	server.mux.HandleFunc("/events", server.serveEvents)
+
+	return server
+}
+
+// ServeHTTP implements http.Handler.
+func (server *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	server.mux.ServeHTTP(w, r)
+}
+
+func (server *Server) serveIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := templates.ExecuteTemplate(w, "index", snapshot(server.root)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (server *Server) serveTree(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := templates.ExecuteTemplate(w, "task", snapshot(server.root)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (server *Server) serveLog(w http.ResponseWriter, r *http.Request) {
+	task := find(server.root, r.URL.Query().Get("task"))
+	if task == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	stdout, stderr := task.Output()
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = w.Write(stdout.Bytes())
+	_, _ = w.Write(stderr.Bytes())
+}
+
+func (server *Server) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	events := server.subscribe()
+	defer server.unsubscribe(events)
+
+	for {
+		select {
+		case ev := <-events:
+			data, err := json.Marshal(ev)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, data)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// event is sent to the browser on every task change.
+type event struct {
+	Type   string `json:"type"`
+	Task   string `json:"task"`
+	Status string `json:"status,omitempty"`
+	Output string `json:"output,omitempty"`
+}
+
+func (server *Server) subscribe() chan event {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	events := make(chan event, 256)
+	server.clients[events] = struct{}{}
+	return events
+}
+
+func (server *Server) unsubscribe(events chan event) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	delete(server.clients, events)
+}
+
+// broadcast sends the event to all clients, slow clients miss events.
+func (server *Server) broadcast(ev event) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	for client := range server.clients {
+		select {
+		case client <- ev:
+		default:
+		}
+	}
+}
+
+func (server *Server) status(typ string, task *ci.Task) {
+	status := task.Status()
+	server.broadcast(event{
+		Type:   typ,
+		Task:   task.Path(),
+		Status: status.State(),
+	})
+}
+
+func (server *Server) TaskStarted(task *ci.Task)                     { server.status("start", task) }
+func (server *Server) TaskSkipped(task *ci.Task)                     { server.status("skip", task) }
+func (server *Server) TaskAttempt(task *ci.Task, attempt ci.Attempt) { server.status("attempt", task) }
+func (server *Server) TaskErrored(task *ci.Task, err error)          { server.status("error", task) }
+func (server *Server) TaskFinished(task *ci.Task)                    { server.status("finish", task) }
+
+func (server *Server) TaskOutput(task *ci.Task, stream string, data []byte) {
+	server.broadcast(event{
+		Type:   "output",
+		Task:   task.Path(),
+		Output: string(data),
+	})
+}
+
+// node is a snapshot of a task used for rendering.
+type node struct {
+	Name     string
+	Path     string
+	State    string
+	Duration string
+	Attempt  int
+	Error    string
+	Parallel bool
+	Tasks    []*node
+}
+
+func snapshot(task *ci.Task) *node {
+	status := task.Status()
+	n := &node{
+		Name:     task.Name,
+		Path:     task.Path(),
+		State:    status.State(),
+		Parallel: task.Parallel,
+	}
+
+	switch {
+	case status.Running:
+		n.Duration = ci.FormatDuration(time.Since(status.Started))
+	case status.Done:
+		n.Duration = ci.FormatDuration(status.Finished.Sub(status.Started))
+	}
+	if len(status.Attempts) > 1 {
+		n.Attempt = len(status.Attempts)
+	}
+	if len(status.Attempts) > 0 {
+		if err := status.Attempts[len(status.Attempts)-1].Err; err != nil {
+			n.Error = err.Error()
+		}
+	}
+
+	for _, subtask := range task.Subtasks() {
+		n.Tasks = append(n.Tasks, snapshot(subtask))
+	}
+	return n
+}
+
+// find finds a task by its path.
+func find(task *ci.Task, path string) *ci.Task {
+	if task.Path() == path {
+		return task
+	}
+	for _, subtask := range task.Subtasks() {
+		if found := find(subtask, path); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+var templates = template.Must(template.New("").Parse(`
+{{define "task"}}
+<li class="{{.State}}">
+	<a href="#" data-path="{{.Path}}">{{.Name}}</a>
+	<span class="state">{{.State}}</span>
+	<span class="duration">{{.Duration}}</span>
+	{{if .Parallel}}<span class="parallel">parallel</span>{{end}}
+	{{if .Attempt}}<span class="attempt">attempt {{.Attempt}}</span>{{end}}
+	{{if .Error}}<span class="error">{{.Error}}</span>{{end}}
+	{{if .Tasks}}<ul>{{range .Tasks}}{{template "task" .}}{{end}}</ul>{{end}}
+</li>
+{{end}}
+
+{{define "index"}}<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Name}}</title>
+<style>
+	body { font-family: sans-serif; display: flex; margin: 0; height: 100vh; }
+	#tree { flex: 1; overflow: auto; padding: 1em; }
+	#log { flex: 1; overflow: auto; margin: 0; padding: 1em; background: #222; color: #ddd; }
+	ul { list-style: none; padding-left: 1.5em; }
+	a { color: inherit; text-decoration: none; }
+	a.selected { font-weight: bold; text-decoration: underline; }
+	.state, .duration, .parallel, .attempt { color: #888; font-size: 0.8em; margin-left: 0.5em; }
+	.running > a { color: #07c; }
+	.error > a, .timeout > a, .error > .error { color: #c00; }
+	.allowed-failure > a { color: #c80; }
+	.skipped > a, .pending > a { color: #999; }
+	.cached > a, .resumed > a { color: #090; }
+</style>
+</head>
+<body>
+<ul id="tree">{{template "task" .}}</ul>
+<pre id="log"></pre>
+<script>
+	var tree = document.getElementById("tree");
+	var log = document.getElementById("log");
+	var selected = "";
+
+	function select(path) {
+		selected = path;
+		fetch("log?task=" + encodeURIComponent(path))
+			.then(function(r) { return r.text(); })
+			.then(function(text) { log.textContent = text; });
+		highlight();
+	}
+
+	function highlight() {
+		tree.querySelectorAll("a[data-path]").forEach(function(a) {
+			a.classList.toggle("selected", a.dataset.path === selected);
+		});
+	}
+
+	tree.addEventListener("click", function(ev) {
+		var path = ev.target.dataset && ev.target.dataset.path;
+		if (path !== undefined) {
+			ev.preventDefault();
+			select(path);
+		}
+	});
+
+	var pending = null;
+	function refresh() {
+		if (pending) return;
+		pending = setTimeout(function() {
+			fetch("tree")
+				.then(function(r) {
+					if (!r.ok) throw new Error(r.statusText);
+					return r.text();
+				})
+				.then(function(html) { tree.innerHTML = html; highlight(); })
+				.catch(function() {})
+				.then(function() { pending = null; });
+		}, 200);
+	}
+	setInterval(refresh, 1000);
+
+	var events = new EventSource("events");
+	["start", "skip", "attempt", "error", "finish"].forEach(function(typ) {
+		events.addEventListener(typ, refresh);
+	});
+	events.addEventListener("output", function(ev) {
+		var data = JSON.parse(ev.data);
+		if (data.task === selected) {
+			log.textContent += data.output;
+		}
+	});
+</script>
+</body>
+</html>
+{{end}}
+`))
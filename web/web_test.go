@@ -0,0 +1,172 @@
+package web
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/loov/ci"
+)
+
+// testTree runs a small pipeline with output and a failure.
+func testTree(t *testing.T) *ci.Task {
+	t.Helper()
+	global, err := ci.NewGlobalContext(context.Background(), ".", ci.NewStdOutput(ioutil.Discard, ioutil.Discard))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer global.Cleanup()
+	global.Quiet = true
+
+	root := &ci.Task{Name: "Default", ContinueOnError: true}
+	build := root.Subtask("build")
+	build.Exec = func(context, _ *ci.Context) error {
+		stdout, _, flush := build.CommandOutput(context)
+		defer flush()
+		fmt.Fprintln(stdout, "compiled <main>")
+		return nil
+	}
+	test := root.Subtask("test")
+	test.Exec = func(context, _ *ci.Context) error { return fmt.Errorf("tests failed") }
+
+	if err := root.Run(&global.Context); err == nil {
+		t.Fatal("expected an error")
+	}
+	return root
+}
+
+func get(t *testing.T, handler http.Handler, url string) *httptest.ResponseRecorder {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", url, nil))
+	return recorder
+}
+
+func TestTree(t *testing.T) {
+	server := New(testTree(t))
+
+	response := get(t, server, "/tree")
+	if response.Code != http.StatusOK {
+		t.Fatalf("got status %d", response.Code)
+	}
+	body := response.Body.String()
+	for _, expected := range []string{
+		`<li class="error">`,
+		`data-path="Default/build"`,
+		`<li class="done">`,
+		`<span class="error">tests failed</span>`,
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("tree does not contain %q:\n%s", expected, body)
+		}
+	}
+
+	if response := get(t, server, "/"); response.Code != http.StatusOK || !strings.Contains(response.Body.String(), "Default/test") {
+		t.Errorf("got status %d, expected the index with the tree", response.Code)
+	}
+	if response := get(t, server, "/missing"); response.Code != http.StatusNotFound {
+		t.Errorf("got status %d, expected %d", response.Code, http.StatusNotFound)
+	}
+}
+
+func TestLog(t *testing.T) {
+	server := New(testTree(t))
+
+	response := get(t, server, "/log?task=Default%2Fbuild")
+	if response.Code != http.StatusOK {
+		t.Fatalf("got status %d", response.Code)
+	}
+	if body := response.Body.String(); body != "compiled <main>\n" {
+		t.Errorf("got %q, expected the output of build", body)
+	}
+	if ct := response.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("got content type %q, expected plain text", ct)
+	}
+
+	if response := get(t, server, "/log?task=Default%2Fmissing"); response.Code != http.StatusNotFound {
+		t.Errorf("got status %d, expected %d", response.Code, http.StatusNotFound)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	root := &ci.Task{Name: "Default"}
+	build := root.Subtask("build")
+	server := New(root)
+
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	response, err := http.Get(httpServer.URL + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	if ct := response.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("got content type %q, expected text/event-stream", ct)
+	}
+
+	// wait for the subscription, events before it are not sent
+	for start := time.Now(); ; time.Sleep(time.Millisecond) {
+		server.mu.Lock()
+		clients := len(server.clients)
+		server.mu.Unlock()
+		if clients > 0 {
+			break
+		}
+		if time.Since(start) > 10*time.Second {
+			t.Fatal("client did not subscribe")
+		}
+	}
+
+	server.TaskStarted(build)
+	server.TaskOutput(build, "stdout", []byte("compiling\n"))
+	server.TaskFinished(build)
+
+	expected := []event{
+		{Type: "start", Task: "Default/build", Status: "pending"},
+		{Type: "output", Task: "Default/build", Output: "compiling\n"},
+		{Type: "finish", Task: "Default/build", Status: "pending"},
+	}
+
+	reader := bufio.NewReader(response.Body)
+	for _, want := range expected {
+		typ, data := readEvent(t, reader)
+		if typ != want.Type {
+			t.Errorf("got event %q, expected %q", typ, want.Type)
+		}
+		var got event
+		if err := json.Unmarshal([]byte(data), &got); err != nil {
+			t.Fatalf("invalid data %q: %v", data, err)
+		}
+		if got != want {
+			t.Errorf("got %+v, expected %+v", got, want)
+		}
+	}
+}
+
+// readEvent reads a single server-sent event.
+func readEvent(t *testing.T, reader *bufio.Reader) (typ, data string) {
+	t.Helper()
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			return typ, data
+		case strings.HasPrefix(line, "event: "):
+			typ = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}